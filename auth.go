@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAPIKey only lets through requests sending Authorization: Bearer <key>
+func (app *App) requireAPIKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				app.respondError(w, http.StatusUnauthorized, "Missing or invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
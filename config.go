@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration read from the environment once at startup.
+// See the README for what each variable does.
+type Config struct {
+	MongoURI        string
+	DBName          string
+	Collection      string
+	ConnectAttempts int
+	ConnectDelay    time.Duration
+	RunMigrations   bool
+	DefaultOwnerID  string
+
+	Port           string
+	TemplateDir    string
+	TimeFormat     string
+	DBTimeout      time.Duration
+	RequestTimeout time.Duration
+	ReportTimeout  time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+
+	APIKey             string
+	AdminToken         string
+	AllowedOrigins     []string
+	RateLimitPerMinute int
+	Maintenance        bool
+
+	LogSampleRate float64
+	ListWarnBytes int
+	PurgeAfter    time.Duration
+	PurgeInterval time.Duration
+}
+
+// loadConfig reads and validates the configuration. Unset optional
+// variables take their defaults; the error lists every required variable
+// that is missing and every value that cannot be used, so they can all be
+// fixed at once.
+func loadConfig() (Config, error) {
+	env := &envReader{}
+
+	cfg := Config{
+		MongoURI:        env.required("MONGODB_URI"),
+		DBName:          env.required("DB_NAME"),
+		Collection:      env.string("COLLECTION_NAME", defaultCollection),
+		ConnectAttempts: env.int("MONGO_CONNECT_ATTEMPTS", 5, 1),
+		ConnectDelay:    env.duration("MONGO_CONNECT_DELAY", time.Second, false),
+		RunMigrations:   env.bool("RUN_MIGRATIONS", true),
+		DefaultOwnerID:  strings.TrimSpace(os.Getenv("DEFAULT_OWNER_ID")),
+
+		Port:           env.string("PORT", "9000"),
+		TemplateDir:    env.string("TEMPLATE_DIR", "./templates"),
+		TimeFormat:     env.oneOf("TIME_FORMAT", timeFormatRFC3339, timeFormatRFC3339, timeFormatUnix, timeFormatUnixMillis),
+		DBTimeout:      env.duration("DB_TIMEOUT", 10*time.Second, false),
+		RequestTimeout: env.duration("REQUEST_TIMEOUT", 60*time.Second, false),
+		ReportTimeout:  env.duration("REPORT_TIMEOUT", 5*time.Minute, false),
+		ReadTimeout:    env.duration("READ_TIMEOUT", 15*time.Second, false),
+		IdleTimeout:    env.duration("IDLE_TIMEOUT", 60*time.Second, false),
+
+		APIKey:             os.Getenv("API_KEY"),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		AllowedOrigins:     splitList(os.Getenv("ALLOWED_ORIGINS")),
+		RateLimitPerMinute: env.int("RATE_LIMIT_PER_MINUTE", 60, 0),
+		Maintenance:        env.bool("MAINTENANCE_MODE", false),
+
+		LogSampleRate: env.float("LOG_SAMPLE_RATE", 1, 0, 1),
+		ListWarnBytes: env.int("LIST_WARN_BYTES", 1<<20, 0),
+		PurgeAfter:    env.duration("PURGE_AFTER", 0, true),
+		PurgeInterval: env.duration("PURGE_INTERVAL", time.Hour, false),
+	}
+	// The default write timeout follows the request timeout, see main
+	cfg.WriteTimeout = env.duration("WRITE_TIMEOUT", cfg.RequestTimeout+15*time.Second, false)
+
+	if len(env.problems) > 0 {
+		return Config{}, errors.New("invalid configuration: " + strings.Join(env.problems, "; "))
+	}
+	return cfg, nil
+}
+
+// envReader reads environment variables, collecting a problem for each
+// one that is missing or invalid instead of stopping at the first
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) invalid(key, value, want string) {
+	e.problems = append(e.problems, fmt.Sprintf("%s=%q is not %s", key, value, want))
+}
+
+func (e *envReader) required(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		e.problems = append(e.problems, key+" is required")
+	}
+	return value
+}
+
+func (e *envReader) string(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func (e *envReader) oneOf(key, def string, allowed ...string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	e.invalid(key, value, "one of "+strings.Join(allowed, ", "))
+	return def
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.invalid(key, value, "true or false")
+		return def
+	}
+	return b
+}
+
+// int reads an integer of at least least
+func (e *envReader) int(key string, def, least int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < least {
+		e.invalid(key, value, fmt.Sprintf("an integer of at least %d", least))
+		return def
+	}
+	return n
+}
+
+// float reads a number from lo to hi
+func (e *envReader) float(key string, def, lo, hi float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < lo || f > hi {
+		e.invalid(key, value, fmt.Sprintf("a number from %g to %g", lo, hi))
+		return def
+	}
+	return f
+}
+
+// duration reads a duration such as "30s", which must be positive unless
+// allowZero is set
+func (e *envReader) duration(key string, def time.Duration, allowZero bool) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || (d == 0 && !allowZero) {
+		want := "a positive duration such as 30s"
+		if allowZero {
+			want = "a duration such as 30s, or 0"
+		}
+		e.invalid(key, value, want)
+		return def
+	}
+	return d
+}
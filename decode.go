@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// maxBodyBytes bounds every JSON request body
+const maxBodyBytes = 1 << 20
+
+// decodeJSON decodes the request body into v, reading at most maxBodyBytes
+// and rejecting fields that v does not declare
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// requireJSON rejects POST, PUT and PATCH requests whose body is not
+// declared as application/json with 415. Requests without a body pass, as
+// some writes such as toggling completion take none.
+func (app *App) requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// ParseMediaType lowercases the type and allows parameters such as charset
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			app.respondError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// jsonTypeName describes the JSON value expected for a Go type
+func jsonTypeName(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(timeInput{}) {
+		switch timeFormat {
+		case timeFormatUnix:
+			return "an RFC3339 timestamp or Unix time in seconds"
+		case timeFormatUnixMillis:
+			return "an RFC3339 timestamp or Unix time in milliseconds"
+		}
+		return "an RFC3339 timestamp"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// invalidBody responds to an error returned by decodeJSON
+func (app *App) invalidBody(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		app.respondError(w, http.StatusRequestEntityTooLarge, "Request body too large, the limit is 1 MiB")
+		return
+	}
+
+	var badTime *time.ParseError
+	if errors.As(err, &badTime) {
+		app.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid timestamp %q, expected RFC3339 such as 2024-01-31T17:00:00Z", badTime.Value))
+		return
+	}
+
+	var badType *json.UnmarshalTypeError
+	if errors.As(err, &badType) {
+		if badType.Field == "" {
+			app.respondError(w, http.StatusBadRequest, "Request body must be "+jsonTypeName(badType.Type))
+			return
+		}
+		app.respondError(w, http.StatusBadRequest, fmt.Sprintf("Field %q must be %s", badType.Field, jsonTypeName(badType.Type)))
+		return
+	}
+
+	// encoding/json has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		app.respondError(w, http.StatusBadRequest, "Unknown field "+field)
+		return
+	}
+
+	app.respondError(w, http.StatusBadRequest, "Invalid request body")
+}
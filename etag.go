@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// etagOf returns the ETag of a response body: the same for identical bodies
+// and a new one when any byte changes
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeWithETag tags a 200 response rendered by write with the ETag of its
+// body. When If-None-Match already names it only a 304 is sent.
+func writeWithETag(w http.ResponseWriter, r *http.Request, write func(http.ResponseWriter)) {
+	buf := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	write(buf)
+
+	for key, values := range buf.header {
+		w.Header()[key] = values
+	}
+
+	if buf.status == http.StatusOK {
+		etag := etagOf(buf.body.Bytes())
+		w.Header().Set("ETag", etag)
+		// Caches must revalidate, which is a cheap 304 while nothing changed
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.WriteHeader(buf.status)
+	if _, err := w.Write(buf.body.Bytes()); err != nil {
+		slog.Warn("Failed to write response", "status", buf.status, "error", err.Error())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header names etag. Weak
+// comparison applies, as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
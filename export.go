@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// csvHeader is the first row of the CSV export
+var csvHeader = []string{"id", "title", "completed", "createdAt"}
+
+// csvSafe keeps spreadsheet apps from evaluating a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (app *App) exportCSV(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTodoFilter(r.URL.Query(), time.Now())
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sort, err := parseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+
+	// Rows are written while the cursor is iterated, so headers go out with
+	// the first row and later errors can only cut the file short
+	cw := csv.NewWriter(w)
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="todos.csv"`)
+		return cw.Write(csvHeader)
+	}
+
+	err = app.store.Each(ctx, filter, sort, func(todo Todo) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return cw.Write([]string{
+			todo.ID.Hex(),
+			csvSafe(todo.Title),
+			strconv.FormatBool(todo.Completed),
+			todo.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	})
+	switch {
+	case err != nil && !started:
+		app.storeFailed(w, err, "Failed to export todos")
+		return
+	case err != nil:
+		slog.Error("CSV export aborted", "request_id", middleware.GetReqID(ctx), "error", err.Error())
+		return
+	case !started:
+		// No matching todos, still send a file with the header row
+		start()
+	}
+	cw.Flush()
+}
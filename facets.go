@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// facetableFields lists the fields clients may request facet counts for.
+// Each has an index in todoIndexes; add one there before adding a field here.
+var facetableFields = map[string]bool{
+	"completed": true,
+	"priority":  true,
+	"tags":      true,
+}
+
+// arrayFields are facetable fields holding arrays, counted per element
+var arrayFields = map[string]bool{
+	"tags": true,
+}
+
+// facetCount is the number of matching todos sharing one value of a field
+type facetCount struct {
+	Value interface{} `json:"value" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+// parseFacets parses a comma-separated ?facets= value, rejecting fields that
+// are not in facetableFields
+func parseFacets(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if !facetableFields[field] {
+			return nil, fmt.Errorf("invalid facet field %q", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Facets counts the todos matching filter per value of each field in a
+// single $facet aggregation
+func (s *MongoTodoStore) Facets(ctx context.Context, filter bson.M, fields []string) (map[string][]facetCount, error) {
+	facet := bson.M{}
+	for _, field := range fields {
+		stages := bson.A{}
+		if arrayFields[field] {
+			stages = append(stages, bson.M{"$unwind": "$" + field})
+		}
+		facet[field] = append(stages,
+			bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		)
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$facet": facet},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, scopePipeline(ctx, pipeline))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	facets := map[string][]facetCount{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&facets); err != nil {
+			return nil, err
+		}
+	}
+	return facets, cursor.Err()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// searchableFields lists the string fields ?q= may match against
+var searchableFields = []string{"title", "tags"}
+
+// filterBuilder accumulates query conditions parsed from request parameters
+// and combines them into a single Mongo filter. Conditions are joined with
+// $and so two filters on the same field never overwrite each other.
+type filterBuilder struct {
+	conditions []bson.M
+}
+
+func newFilterBuilder() *filterBuilder {
+	return &filterBuilder{}
+}
+
+// eq adds an equality condition on field
+func (b *filterBuilder) eq(field string, value interface{}) *filterBuilder {
+	return b.where(bson.M{field: value})
+}
+
+// where adds an arbitrary condition, ignoring empty ones
+func (b *filterBuilder) where(condition bson.M) *filterBuilder {
+	if len(condition) > 0 {
+		b.conditions = append(b.conditions, condition)
+	}
+	return b
+}
+
+// build returns the combined filter, matching everything when no conditions were added
+func (b *filterBuilder) build() bson.M {
+	switch len(b.conditions) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return b.conditions[0]
+	default:
+		and := make(bson.A, 0, len(b.conditions))
+		for _, condition := range b.conditions {
+			and = append(and, condition)
+		}
+		return bson.M{"$and": and}
+	}
+}
+
+// parseSearchIn parses a comma-separated ?search_in= value, defaulting to every searchable field
+func parseSearchIn(value string) ([]string, error) {
+	if value == "" {
+		return searchableFields, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !contains(searchableFields, field) {
+			return nil, fmt.Errorf("invalid search_in field %q, allowed: %s", field, strings.Join(searchableFields, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// searchCondition matches documents where any of fields contains q,
+// case-insensitively. Regex metacharacters in q are matched literally.
+func searchCondition(q string, fields []string) bson.M {
+	q = strings.TrimSpace(q)
+	if q == "" || len(fields) == 0 {
+		return nil
+	}
+
+	pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+	or := make(bson.A, 0, len(fields))
+	for _, field := range fields {
+		or = append(or, bson.M{field: pattern})
+	}
+	return bson.M{"$or": or}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBoolParam parses an optional query value that must be exactly "true"
+// or "false". It returns nil when the parameter is absent.
+func parseBoolParam(name, value string) (*bool, error) {
+	switch value {
+	case "":
+		return nil, nil
+	case "true", "false":
+		b := value == "true"
+		return &b, nil
+	default:
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+}
+
+// overdueCondition matches incomplete todos whose due date has passed, or
+// every other todo when overdue is false
+func overdueCondition(overdue bool, now time.Time) bson.M {
+	condition := bson.M{"dueDate": bson.M{"$lt": now}, "completed": false}
+	if overdue {
+		return condition
+	}
+	return bson.M{"$nor": bson.A{condition}}
+}
+
+// notDeletedCondition excludes soft-deleted todos
+func notDeletedCondition() bson.M {
+	return bson.M{"deletedAt": bson.M{"$exists": false}}
+}
+
+// parseTodoFilter builds the filter for the list query parameters shared by
+// the todo list and the CSV export: q, search_in, completed, overdue,
+// priority, tag and includeDeleted
+func parseTodoFilter(query url.Values, now time.Time) (bson.M, error) {
+	searchIn, err := parseSearchIn(query.Get("search_in"))
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := parseBoolParam("completed", query.Get("completed"))
+	if err != nil {
+		return nil, err
+	}
+
+	overdue, err := parseBoolParam("overdue", query.Get("overdue"))
+	if err != nil {
+		return nil, err
+	}
+
+	includeDeleted, err := parseBoolParam("includeDeleted", query.Get("includeDeleted"))
+	if err != nil {
+		return nil, err
+	}
+
+	priority := query.Get("priority")
+	if msg := validatePriority(priority); msg != "" {
+		return nil, errors.New(msg)
+	}
+
+	tags := normalizeTags(query["tag"])
+
+	filter := newFilterBuilder()
+	filter.where(searchCondition(query.Get("q"), searchIn))
+	if priority != "" {
+		filter.eq("priority", priority)
+	}
+	if completed != nil {
+		filter.eq("completed", *completed)
+	}
+	if overdue != nil {
+		filter.where(overdueCondition(*overdue, now))
+	}
+	if len(tags) > 0 {
+		filter.where(bson.M{"tags": bson.M{"$all": tags}})
+	}
+	if includeDeleted == nil || !*includeDeleted {
+		filter.where(notDeletedCondition())
+	}
+	return filter.build(), nil
+}
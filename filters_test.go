@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFilterBuilderBuild(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *filterBuilder)
+		want  bson.M
+	}{
+		{
+			name:  "no conditions",
+			build: func(b *filterBuilder) {},
+			want:  bson.M{},
+		},
+		{
+			name:  "empty conditions are ignored",
+			build: func(b *filterBuilder) { b.where(nil).where(bson.M{}) },
+			want:  bson.M{},
+		},
+		{
+			name:  "one condition",
+			build: func(b *filterBuilder) { b.eq("completed", true) },
+			want:  bson.M{"completed": true},
+		},
+		{
+			name: "many conditions",
+			build: func(b *filterBuilder) {
+				b.eq("completed", true).eq("priority", "high").where(notDeletedCondition())
+			},
+			want: bson.M{"$and": bson.A{
+				bson.M{"completed": true},
+				bson.M{"priority": "high"},
+				bson.M{"deletedAt": bson.M{"$exists": false}},
+			}},
+		},
+		{
+			// Conditions on the same field are kept side by side instead of
+			// the later one replacing the earlier one
+			name: "same field twice",
+			build: func(b *filterBuilder) {
+				b.where(bson.M{"tags": bson.M{"$all": []string{"home"}}}).eq("tags", "work")
+			},
+			want: bson.M{"$and": bson.A{
+				bson.M{"tags": bson.M{"$all": []string{"home"}}},
+				bson.M{"tags": "work"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFilterBuilder()
+			tt.build(b)
+			if got := b.build(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("build() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTodoFilter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	notDeleted := notDeletedCondition()
+
+	tests := []struct {
+		name  string
+		query string
+		want  bson.M
+	}{
+		{
+			name:  "no parameters",
+			query: "",
+			want:  notDeleted,
+		},
+		{
+			name:  "include deleted",
+			query: "includeDeleted=true",
+			want:  bson.M{},
+		},
+		{
+			name:  "completed",
+			query: "completed=false",
+			want:  bson.M{"$and": bson.A{bson.M{"completed": false}, notDeleted}},
+		},
+		{
+			name:  "search in titles",
+			query: "q=a.b&search_in=title",
+			want: bson.M{"$and": bson.A{
+				bson.M{"$or": bson.A{bson.M{"title": bson.M{"$regex": `a\.b`, "$options": "i"}}}},
+				notDeleted,
+			}},
+		},
+		{
+			name:  "blank search",
+			query: "q=%20%20",
+			want:  notDeleted,
+		},
+		{
+			name:  "overdue",
+			query: "overdue=true",
+			want: bson.M{"$and": bson.A{
+				bson.M{"dueDate": bson.M{"$lt": now}, "completed": false},
+				notDeleted,
+			}},
+		},
+		{
+			name:  "not overdue",
+			query: "overdue=false",
+			want: bson.M{"$and": bson.A{
+				bson.M{"$nor": bson.A{bson.M{"dueDate": bson.M{"$lt": now}, "completed": false}}},
+				notDeleted,
+			}},
+		},
+		{
+			name:  "tags are normalized",
+			query: "tag=Home&tag=%20work&tag=home",
+			want: bson.M{"$and": bson.A{
+				bson.M{"tags": bson.M{"$all": []string{"home", "work"}}},
+				notDeleted,
+			}},
+		},
+		{
+			name:  "everything",
+			query: "q=milk&priority=high&completed=true&tag=home&includeDeleted=false",
+			want: bson.M{"$and": bson.A{
+				searchCondition("milk", searchableFields),
+				bson.M{"priority": "high"},
+				bson.M{"completed": true},
+				bson.M{"tags": bson.M{"$all": []string{"home"}}},
+				notDeleted,
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseTodoFilter(query, now)
+			if err != nil {
+				t.Fatalf("parseTodoFilter(%q) error = %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTodoFilter(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTodoFilterErrors(t *testing.T) {
+	tests := []string{
+		"completed=yes",
+		"overdue=1",
+		"includeDeleted=TRUE",
+		"priority=urgent",
+		"search_in=title,notes",
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			values, err := url.ParseQuery(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filter, err := parseTodoFilter(values, time.Now()); err == nil {
+				t.Errorf("parseTodoFilter(%q) = %v, want an error", query, filter)
+			}
+		})
+	}
+}
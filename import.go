@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// prepareForImport fills in what an imported todo may lack while keeping
+// the ID and timestamps it was exported with
+func (t *Todo) prepareForImport() {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = t.ID.Timestamp()
+	}
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
+	}
+	if t.Priority == "" {
+		t.Priority = defaultPriority
+	}
+	t.PriorityRank = priorityRanks[t.Priority]
+	if t.Version < 1 {
+		t.Version = 1
+	}
+	t.CreatedAgo = ""
+}
+
+func (app *App) importTodos(w http.ResponseWriter, r *http.Request) {
+	var todos []Todo
+	if err := decodeJSON(w, r, &todos); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	if len(todos) == 0 {
+		app.respondError(w, http.StatusBadRequest, "The import must contain at least one todo")
+		return
+	}
+
+	// Check the whole batch first so a bad item imports nothing
+	seen := make(map[primitive.ObjectID]int, len(todos))
+	for i := range todos {
+		if todos[i].ID.IsZero() {
+			app.respondErrorDetails(w, http.StatusBadRequest,
+				fmt.Sprintf("Todo at index %d has no id", i),
+				renderer.M{"index": i},
+			)
+			return
+		}
+		if first, ok := seen[todos[i].ID]; ok {
+			app.respondErrorDetails(w, http.StatusBadRequest,
+				fmt.Sprintf("Todo at index %d has the same id as index %d", i, first),
+				renderer.M{"index": i},
+			)
+			return
+		}
+		seen[todos[i].ID] = i
+
+		if err := todos[i].Validate(); err != nil {
+			app.respondErrorDetails(w, http.StatusUnprocessableEntity,
+				fmt.Sprintf("Todo at index %d is invalid: %s", i, err.Error()),
+				renderer.M{"index": i, "fields": err},
+			)
+			return
+		}
+		todos[i].prepareForImport()
+	}
+
+	ctx := r.Context()
+
+	ids := make([]primitive.ObjectID, len(todos))
+	for i := range todos {
+		ids[i] = todos[i].ID
+	}
+	if !app.titlesAvailable(ctx, w, todos, ids) {
+		return
+	}
+
+	inserted, updated, err := app.store.Upsert(ctx, todos)
+	if err == errDuplicateID {
+		app.respondError(w, http.StatusConflict, "A todo in the import has an id used by another user's todo, todos before it were imported")
+		return
+	}
+	if err == errDuplicateTitle {
+		// Only possible when todos of the import swap titles, or another
+		// request took a title since titlesAvailable checked
+		app.respondError(w, http.StatusConflict, "A todo in the import has a title already used by another todo, todos before it were imported")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to import todos")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"inserted": inserted,
+		"updated":  updated,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// todoIndexes are created at startup. CreateMany is a no-op for indexes that
+// already exist with the same keys and options, so restarts are safe.
+// Titles are only unique among the open todos of each owner, so completed
+// occurrences of a recurring todo can share the title of the next one.
+var todoIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "title", Value: 1}},
+		Options: options.Index().SetName("owner_title_open_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"completed": false}),
+	},
+	{
+		// Serves the default list order within an owner's todos
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: 1}},
+		Options: options.Index().SetName("owner_created_at"),
+	},
+	{
+		Keys:    bson.D{{Key: "tags", Value: 1}},
+		Options: options.Index().SetName("tags"),
+	},
+	// completed, priority and tags back the facetableFields
+	{
+		Keys:    bson.D{{Key: "completed", Value: 1}},
+		Options: options.Index().SetName("completed"),
+	},
+	{
+		Keys:    bson.D{{Key: "priority", Value: 1}},
+		Options: options.Index().SetName("priority"),
+	},
+}
+
+// snapshotIndexes are created on the snapshots collection. Names are unique
+// per owner, so two requests creating the same snapshot cannot both succeed.
+var snapshotIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetName("owner_name_unique").SetUnique(true),
+	},
+}
+
+// snapshotItemIndexes serve reading the items of a snapshot in ID order
+var snapshotItemIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "snapshotId", Value: 1}, {Key: "todo._id", Value: 1}},
+		Options: options.Index().SetName("snapshot_todo"),
+	},
+}
+
+func ensureIndexes(ctx context.Context, todos *mongo.Collection) error {
+	if _, err := todos.Indexes().CreateMany(ctx, todoIndexes); err != nil {
+		return err
+	}
+	if _, err := companionCollection(todos, "snapshots").Indexes().CreateMany(ctx, snapshotIndexes); err != nil {
+		return err
+	}
+	_, err := companionCollection(todos, "snapshot_items").Indexes().CreateMany(ctx, snapshotItemIndexes)
+	return err
+}
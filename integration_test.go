@@ -0,0 +1,113 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Run with go test -tags integration ./..., which needs Docker to start MongoDB
+
+// newIntegrationServer starts MongoDB in a container and returns the full
+// router backed by a migrated and indexed todos collection in it
+func newIntegrationServer(t *testing.T) http.Handler {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := mongodb.Run(ctx, "mongo:7")
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("starting MongoDB: %v", err)
+	}
+
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	cfg := testConfig()
+	todos := client.Database("todoapp_test").Collection(defaultCollection)
+	if err := runMigrations(ctx, todos, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureIndexes(ctx, todos); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestServerConfig(newMongoTodoStore(todos), cfg)
+}
+
+func TestIntegrationTodoLifecycle(t *testing.T) {
+	h := newIntegrationServer(t)
+
+	var created Todo
+	decodeResponse(t, send(h, http.MethodPost, "/api/v1/todos", `{"title": "Buy milk", "tags": ["home"]}`), http.StatusCreated, &created)
+	if created.ID.IsZero() || created.Title != "Buy milk" || created.Version != 1 {
+		t.Fatalf("created = %+v", created)
+	}
+	location := todoLocation(created.ID)
+
+	var list []Todo
+	decodeResponse(t, send(h, http.MethodGet, "/api/v1/todos?tag=home", ""), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("list = %+v, want only the created todo", list)
+	}
+
+	var fetched Todo
+	decodeResponse(t, send(h, http.MethodGet, location, ""), http.StatusOK, &fetched)
+	if fetched.Title != "Buy milk" {
+		t.Errorf("fetched title = %q, want %q", fetched.Title, "Buy milk")
+	}
+
+	var updated Todo
+	decodeResponse(t, send(h, http.MethodPut, location, `{"completed": true, "version": 1}`), http.StatusOK, &updated)
+	if !updated.Completed || updated.CompletedAt == nil || updated.Title != "Buy milk" || updated.Version != 2 {
+		t.Errorf("updated = %+v, want it completed at version 2 with its title kept", updated)
+	}
+
+	// The version sent was current only before the update
+	decodeResponse(t, send(h, http.MethodPut, location, `{"title": "Buy bread", "version": 1}`), http.StatusConflict, nil)
+
+	decodeResponse(t, send(h, http.MethodDelete, location, ""), http.StatusOK, nil)
+	decodeResponse(t, send(h, http.MethodGet, location, ""), http.StatusNotFound, nil)
+	decodeResponse(t, send(h, http.MethodDelete, location, ""), http.StatusNotFound, nil)
+}
+
+func TestIntegrationErrors(t *testing.T) {
+	h := newIntegrationServer(t)
+
+	decodeResponse(t, send(h, http.MethodPost, "/api/v1/todos", `{"title": "Taken"}`), http.StatusCreated, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"bad id", http.MethodGet, "/api/v1/todos/not-an-id", "", http.StatusBadRequest},
+		{"bad id on update", http.MethodPut, "/api/v1/todos/123", `{"title": "x"}`, http.StatusBadRequest},
+		{"missing title", http.MethodPost, "/api/v1/todos", `{"priority": "high"}`, http.StatusUnprocessableEntity},
+		{"duplicate title", http.MethodPost, "/api/v1/todos", `{"title": "Taken"}`, http.StatusConflict},
+		{"unknown todo", http.MethodGet, "/api/v1/todos/000000000000000000000000", "", http.StatusNotFound},
+		{"unknown todo on update", http.MethodPut, "/api/v1/todos/000000000000000000000000", `{"title": "x"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decodeResponse(t, send(h, tt.method, tt.path, tt.body), tt.status, nil)
+		})
+	}
+}
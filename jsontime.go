@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Supported values for the TIME_FORMAT environment variable
+const (
+	timeFormatRFC3339    = "rfc3339"
+	timeFormatUnix       = "unix"
+	timeFormatUnixMillis = "unixms"
+)
+
+// timeFormat controls how timestamps are serialized in JSON responses. It is
+// set once at startup by setTimeFormat and read by the MarshalJSON methods.
+var timeFormat = timeFormatRFC3339
+
+func setTimeFormat(format string) {
+	switch format {
+	case "":
+		timeFormat = timeFormatRFC3339
+	case timeFormatRFC3339, timeFormatUnix, timeFormatUnixMillis:
+		timeFormat = format
+	default:
+		log.Printf("Unknown TIME_FORMAT %q, using %s", format, timeFormatRFC3339)
+		timeFormat = timeFormatRFC3339
+	}
+}
+
+// jsonTime returns t in the configured response format
+func jsonTime(t time.Time) interface{} {
+	switch timeFormat {
+	case timeFormatUnix:
+		return t.Unix()
+	case timeFormatUnixMillis:
+		return t.UnixMilli()
+	default:
+		return t
+	}
+}
+
+// timeInput is a timestamp in a request body. RFC3339 is always accepted;
+// with TIME_FORMAT=unix or unixms so is the epoch number responses carry,
+// so a fetched todo can be sent back as it is.
+type timeInput time.Time
+
+func (t *timeInput) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] == '"' || string(data) == "null" {
+		return (*time.Time)(t).UnmarshalJSON(data)
+	}
+
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	switch {
+	case err == nil && timeFormat == timeFormatUnix:
+		*t = timeInput(time.Unix(n, 0))
+	case err == nil && timeFormat == timeFormatUnixMillis:
+		*t = timeInput(time.UnixMilli(n))
+	default:
+		return &json.UnmarshalTypeError{Value: "number", Type: reflect.TypeOf(time.Time{})}
+	}
+	return nil
+}
+
+// timeFrom returns the time.Time held by t, or nil
+func timeFrom(t *timeInput) *time.Time {
+	if t == nil {
+		return nil
+	}
+	tt := time.Time(*t)
+	return &tt
+}
+
+func (t Todo) MarshalJSON() ([]byte, error) {
+	type todoJSON Todo
+	out := struct {
+		todoJSON
+		CreatedAt   interface{} `json:"createdAt"`
+		UpdatedAt   interface{} `json:"updatedAt"`
+		CompletedAt interface{} `json:"completedAt,omitempty"`
+		DueDate     interface{} `json:"dueDate,omitempty"`
+		DeletedAt   interface{} `json:"deletedAt,omitempty"`
+	}{
+		todoJSON:  todoJSON(t),
+		CreatedAt: jsonTime(t.CreatedAt),
+		UpdatedAt: jsonTime(t.UpdatedAt),
+	}
+	if t.CompletedAt != nil {
+		out.CompletedAt = jsonTime(*t.CompletedAt)
+	}
+	if t.DueDate != nil {
+		out.DueDate = jsonTime(*t.DueDate)
+	}
+	if t.DeletedAt != nil {
+		out.DeletedAt = jsonTime(*t.DeletedAt)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads the timestamps as timeInput and, like decodeJSON,
+// rejects unknown fields, which the outer decoder no longer checks once a
+// type has its own UnmarshalJSON
+func (t *Todo) UnmarshalJSON(data []byte) error {
+	type todoJSON Todo
+	in := struct {
+		*todoJSON
+		CreatedAt   json.RawMessage `json:"createdAt"`
+		UpdatedAt   json.RawMessage `json:"updatedAt"`
+		CompletedAt json.RawMessage `json:"completedAt"`
+		DueDate     json.RawMessage `json:"dueDate"`
+		DeletedAt   json.RawMessage `json:"deletedAt"`
+	}{todoJSON: (*todoJSON)(t)}
+	if err := unmarshalStrict(data, &in); err != nil {
+		return err
+	}
+
+	var createdAt, updatedAt *time.Time
+	fields := []struct {
+		name string
+		raw  json.RawMessage
+		dst  **time.Time
+	}{
+		{"createdAt", in.CreatedAt, &createdAt},
+		{"updatedAt", in.UpdatedAt, &updatedAt},
+		{"completedAt", in.CompletedAt, &t.CompletedAt},
+		{"dueDate", in.DueDate, &t.DueDate},
+		{"deletedAt", in.DeletedAt, &t.DeletedAt},
+	}
+	for _, f := range fields {
+		if err := decodeTime(f.name, f.raw, f.dst); err != nil {
+			return err
+		}
+	}
+	if createdAt != nil {
+		t.CreatedAt = *createdAt
+	}
+	if updatedAt != nil {
+		t.UpdatedAt = *updatedAt
+	}
+	return nil
+}
+
+// decodeTime decodes raw, the value of field, into dst as a timeInput. An
+// absent field leaves dst untouched, null sets it to nil. encoding/json does
+// not name the field in errors from UnmarshalJSON methods, so it is added here.
+func decodeTime(field string, raw json.RawMessage, dst **time.Time) error {
+	if raw == nil {
+		return nil
+	}
+
+	var in *timeInput
+	if err := json.Unmarshal(raw, &in); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			typeErr.Field = field
+		}
+		return err
+	}
+	*dst = timeFrom(in)
+	return nil
+}
+
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	type snapshotJSON Snapshot
+	return json.Marshal(struct {
+		snapshotJSON
+		CreatedAt interface{} `json:"createdAt"`
+	}{
+		snapshotJSON: snapshotJSON(s),
+		CreatedAt:    jsonTime(s.CreatedAt),
+	})
+}
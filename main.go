@@ -3,11 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -20,82 +26,159 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Renderer is the subset of *renderer.Render the handlers use, so tests can
+// substitute their own implementation
+type Renderer interface {
+	JSON(w http.ResponseWriter, status int, v interface{}) error
+	HTML(w http.ResponseWriter, status int, name string, v interface{}) error
+}
+
 // App represents the application
 type App struct {
-	renderer *renderer.Render
-	db       *mongo.Database
+	renderer Renderer
+	store    TodoStore
+
+	// dbTimeout bounds the database work of a single request
+	dbTimeout time.Duration
+	// listWarnBytes is the getTodos response size that triggers a warning, 0 disables it
+	listWarnBytes int
+	// maintenance makes the API reject writes with 503 while set
+	maintenance atomic.Bool
+	// undo holds hard-deleted todos for POST /todos/undo
+	undo *undoStore
 }
 
 // Todo represents the todo model
 type Todo struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Title     string             `json:"title" bson:"title"`
-	Completed bool               `json:"completed" bson:"completed"`
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Title       string             `json:"title" bson:"title"`
+	Completed   bool               `json:"completed" bson:"completed"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"` // set when first completed, cleared when reopened
+	DueDate     *time.Time         `json:"dueDate,omitempty" bson:"dueDate,omitempty"`
+	Priority    string             `json:"priority" bson:"priority"`
+	Tags        []string           `json:"tags" bson:"tags"`
+	Subtasks    []Subtask          `json:"subtasks" bson:"subtasks"`
+	Recurrence  string             `json:"recurrence,omitempty" bson:"recurrence"`
+	OwnerID     string             `json:"-" bson:"ownerId"`                               // from X-User-ID, see requireOwner
+	Version     int                `json:"version" bson:"version"`                         // incremented on every write
+	DeletedAt   *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"` // set by a soft delete
+
+	// PriorityRank mirrors Priority as a number so the list can sort by it
+	PriorityRank int `json:"-" bson:"priorityRank"`
+	// CreatedAgo is a human readable age filled in on list responses only
+	CreatedAgo string `json:"createdAgo,omitempty" bson:"-"`
 }
 
 func main() {
+	// Log as JSON, including output from the log package
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
 
-	// Initialize renderer with templates
+	// Read and check all configuration before connecting to anything
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	setTimeFormat(cfg.TimeFormat)
+
+	// Initialize renderer with templates. Check the glob first so a wrong
+	// directory fails here instead of as a 500 on the home page.
+	templatePattern := filepath.Join(cfg.TemplateDir, "*.html")
+	templates, err := filepath.Glob(templatePattern)
+	if err != nil || len(templates) == 0 {
+		log.Fatalf("No templates found matching %s, set TEMPLATE_DIR to the templates directory", templatePattern)
+	}
+	slog.Info("Loaded templates", "pattern", templatePattern, "files", templates)
+
 	rnd := renderer.New(renderer.Options{
-		ParseGlobPattern: "./templates/*.html",
+		ParseGlobPattern: templatePattern,
 	})
 
 	// Connect to MongoDB
-	client, err := connectToMongoDB()
+	client, err := connectToMongoDB(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 	defer client.Disconnect(context.Background())
 
-	db := client.Database(os.Getenv("DB_NAME"))
-	app := &App{
-		renderer: rnd,
-		db:       db,
+	todos := client.Database(cfg.DBName).Collection(cfg.Collection)
+
+	// Apply pending schema migrations
+	if cfg.RunMigrations {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		err := runMigrations(ctx, todos, cfg)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
 	}
 
-	// Create router
-	router := chi.NewRouter()
+	// Create indexes. Existing duplicate titles make the unique index fail;
+	// keep serving so they can be cleaned up via /api/v1/todos/duplicates.
+	indexCtx, cancelIndex := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := ensureIndexes(indexCtx, todos); err != nil {
+		log.Printf("Failed to create indexes: %v", err)
+	}
+	cancelIndex()
 
-	// Middleware
-	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(60 * time.Second))
+	app := &App{
+		renderer:      rnd,
+		store:         newMongoTodoStore(todos),
+		dbTimeout:     cfg.DBTimeout,
+		listWarnBytes: cfg.ListWarnBytes,
+		undo:          newUndoStore(undoTTL),
+	}
 
-	// Static files
-	workDir, _ := os.Getwd()
-	filesDir := http.Dir(filepath.Join(workDir, "static"))
-	router.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(filesDir)))
+	app.maintenance.Store(cfg.Maintenance)
 
-	// Routes
-	router.Get("/", app.homeHandler)
-	router.Get("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(workDir, "static/favicon.ico"))
-	})
+	if cfg.APIKey == "" {
+		slog.Warn("API_KEY is not set, /api/v1 is open to anyone who can reach it")
+	}
 
-	// API routes
-	router.Route("/api/v1", func(r chi.Router) {
-		r.Get("/todos", app.getTodos)
-		r.Post("/todos", app.createTodo)
-		r.Put("/todos/{id}", app.updateTodo)
-		r.Delete("/todos/{id}", app.deleteTodo)
-	})
+	// The server's WriteTimeout closes the connection without a response
+	if cfg.WriteTimeout <= cfg.RequestTimeout {
+		slog.Warn("WRITE_TIMEOUT does not exceed REQUEST_TIMEOUT, slow requests will be cut off without a response",
+			"write_timeout", cfg.WriteTimeout.String(), "request_timeout", cfg.RequestTimeout.String())
+	}
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9000"
+	metricsCtx, stopMetrics := context.WithCancel(context.Background())
+	defer stopMetrics()
+	go app.trackTodoCount(metricsCtx, 30*time.Second)
+
+	// The purge job is off unless PURGE_AFTER is set. purgeDone is closed
+	// once it has returned, so shutdown can wait for a run in progress.
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	purgeDone := make(chan struct{})
+	if after := cfg.PurgeAfter; after > 0 {
+		interval := cfg.PurgeInterval
+		go func() {
+			defer close(purgeDone)
+			app.purgeCompleted(purgeCtx, interval, after)
+		}()
+		slog.Info("Purging completed todos", "after", after.String(), "interval", interval.String())
+	} else {
+		close(purgeDone)
 	}
 
+	// Start server
+	port := cfg.Port
+
+	// ReadTimeout covers headers and body, so slow clients cannot hold
+	// connections open; IdleTimeout bounds keep-alive connections
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+		Addr:         ":" + port,
+		Handler:      app.routes(cfg, logger),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 
 	// Graceful shutdown
@@ -118,14 +201,45 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown failed: %v", err)
 	}
+	stopPurge()
+	<-purgeDone
 	log.Println("Server stopped gracefully")
 }
 
-func connectToMongoDB() (*mongo.Client, error) {
+// connectToMongoDB connects and pings, retrying with exponential backoff so
+// the app can start before MongoDB is ready, e.g. under Docker Compose
+func connectToMongoDB(cfg Config) (*mongo.Client, error) {
+	attempts := cfg.ConnectAttempts
+	delay := cfg.ConnectDelay
+	const maxDelay = 30 * time.Second
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var client *mongo.Client
+		client, err = connectOnce(cfg.MongoURI)
+		if err == nil {
+			return client, nil
+		}
+		if attempt >= attempts {
+			return nil, err
+		}
+
+		slog.Warn("MongoDB connection failed, retrying",
+			"attempt", attempt,
+			"max_attempts", attempts,
+			"retry_in", delay.String(),
+			"error", err.Error(),
+		)
+		time.Sleep(delay)
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+func connectOnce(uri string) (*mongo.Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(os.Getenv("MONGODB_URI"))
+	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
@@ -133,143 +247,709 @@ func connectToMongoDB() (*mongo.Client, error) {
 
 	// Verify connection
 	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
 		return nil, err
 	}
 
 	return client, nil
 }
 
-func (app *App) homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := app.renderer.HTML(w, http.StatusOK, "home", nil)
+// errInvalidID is returned by parseObjectID for anything that is not a valid ObjectID
+var errInvalidID = errors.New("invalid ID format")
+
+// parseObjectID parses a hex ObjectID as used in URL params, ignoring
+// surrounding whitespace. Every by-ID handler goes through it so malformed
+// IDs are rejected the same way everywhere.
+func parseObjectID(idStr string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(idStr))
 	if err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to render home page",
-		})
+		return primitive.NilObjectID, errInvalidID
 	}
+	return id, nil
 }
 
-func (app *App) getTodos(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// idParam parses the {id} URL param, answering 400 itself when it is not
+// a valid ObjectID. Handlers return when ok is false.
+func (app *App) idParam(w http.ResponseWriter, r *http.Request) (id primitive.ObjectID, ok bool) {
+	id, err := parseObjectID(chi.URLParam(r, "id"))
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, "Invalid ID format")
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// todoLocation is the canonical URL of a todo
+func todoLocation(id primitive.ObjectID) string {
+	return "/api/v1/todos/" + id.Hex()
+}
+
+// preferMinimal reports whether the client sent Prefer: return=minimal (RFC 7240).
+// Anything else, including no header, means return=representation.
+func preferMinimal(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			pref = strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if strings.EqualFold(pref, "return=minimal") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeMinimal answers a write with 204 and only a Location header
+func writeMinimal(w http.ResponseWriter, location string) {
+	w.Header().Set("Location", location)
+	w.Header().Set("Preference-Applied", "return=minimal")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// countingWriter records how many body bytes were written through it
+type countingWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.written += n
+	return n, err
+}
+
+func (app *App) homeHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, http.StatusOK, func(w http.ResponseWriter) error {
+		return app.renderer.HTML(w, http.StatusOK, "home", nil)
+	})
+}
+
+func (app *App) healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	cursor, err := app.db.Collection("todos").Find(ctx, bson.M{})
+	if err := app.store.Ping(ctx); err != nil {
+		app.respondError(w, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"status": "ok",
+	})
+}
+
+func (app *App) getTodos(w http.ResponseWriter, r *http.Request) {
+	facetFields, err := parseFacets(r.URL.Query().Get("facets"))
 	if err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to fetch todos",
-		})
+		app.respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var todos []Todo
-	if err = cursor.All(ctx, &todos); err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to decode todos",
-		})
+	filter, err := parseTodoFilter(r.URL.Query(), time.Now())
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := parsePagination(r.URL.Query())
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sort, err := parseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+
+	total, err := app.store.Count(ctx, filter)
+	if err != nil {
+		app.storeFailed(w, err, "Failed to count todos")
+		return
+	}
+
+	todos, err := app.store.List(ctx, filter, sort, page)
+	if err == errNotFound {
+		app.respondError(w, http.StatusBadRequest, "after refers to a todo that no longer exists")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to fetch todos")
 		return
 	}
 
-	app.renderer.JSON(w, http.StatusOK, renderer.M{
-		"data": todos,
+	meta := struct {
+		paginationMeta
+		Facets map[string][]facetCount `json:"facets,omitempty"`
+	}{paginationMeta: page.meta(total, todos)}
+
+	if len(facetFields) > 0 {
+		facets, err := app.store.Facets(ctx, filter, facetFields)
+		if err != nil {
+			app.storeFailed(w, err, "Failed to compute facets")
+			return
+		}
+		meta.Facets = facets
+	}
+
+	locale := localeFromAcceptLanguage(r.Header.Get("Accept-Language"))
+	now := time.Now()
+	for i := range todos {
+		todos[i].CreatedAgo = locale.since(todos[i].CreatedAt, now)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+
+	cw := &countingWriter{ResponseWriter: w}
+	// The ETag is taken from the body as sent, so it also changes when
+	// createdAgo moves on or comes in another language
+	writeWithETag(cw, r, func(w http.ResponseWriter) {
+		app.respondPage(w, http.StatusOK, todos, meta)
 	})
+
+	if app.listWarnBytes > 0 && cw.written > app.listWarnBytes {
+		slog.Warn("large getTodos response",
+			"request_id", middleware.GetReqID(r.Context()),
+			"bytes", cw.written,
+			"threshold", app.listWarnBytes,
+			"query", r.URL.RawQuery,
+		)
+	}
+}
+
+func (app *App) getTodo(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	todo, err := app.store.Get(ctx, objID)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to fetch todo")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, todo)
+}
+
+func (app *App) getTodoPosition(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	sort, err := parseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		app.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+
+	position, err := app.store.Position(ctx, objID, sort)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compute position")
+		return
+	}
+
+	total, err := app.store.Count(ctx, notDeletedCondition())
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compute position")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"position": position,
+		"total":    total,
+	})
+}
+
+// prepareForInsert assigns a fresh ID and the server-controlled timestamps,
+// discarding any values the client sent for them
+func (t *Todo) prepareForInsert(now time.Time) {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	t.Version = 1
+	if t.Priority == "" {
+		t.Priority = defaultPriority
+	}
+	t.PriorityRank = priorityRanks[t.Priority]
+	t.CompletedAt = nil
+	if t.Completed {
+		t.CompletedAt = &now
+	}
 }
 
 func (app *App) createTodo(w http.ResponseWriter, r *http.Request) {
 	var todo Todo
-	if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-		app.renderer.JSON(w, http.StatusBadRequest, renderer.M{
-			"error": "Invalid request body",
-		})
+	if err := decodeJSON(w, r, &todo); err != nil {
+		app.invalidBody(w, err)
 		return
 	}
 
-	if todo.Title == "" {
-		app.renderer.JSON(w, http.StatusBadRequest, renderer.M{
-			"error": "Title is required",
-		})
+	if err := todo.Validate(); err != nil {
+		app.validationFailed(w, err)
 		return
 	}
 
-	todo.ID = primitive.NewObjectID()
-	todo.CreatedAt = time.Now()
+	todo.prepareForInsert(time.Now())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx := r.Context()
 
-	_, err := app.db.Collection("todos").InsertOne(ctx, todo)
+	err := app.store.Create(ctx, todo)
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "A todo with that title already exists")
+		return
+	}
 	if err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to create todo",
-		})
+		app.storeFailed(w, err, "Failed to create todo")
+		return
+	}
+
+	if preferMinimal(r) {
+		writeMinimal(w, todoLocation(todo.ID))
 		return
 	}
 
-	app.renderer.JSON(w, http.StatusCreated, todo)
+	w.Header().Set("Location", todoLocation(todo.ID))
+	app.respondJSON(w, http.StatusCreated, todo)
+}
+
+func (app *App) bulkCreate(w http.ResponseWriter, r *http.Request) {
+	var todos []Todo
+	if err := decodeJSON(w, r, &todos); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	if len(todos) == 0 {
+		app.validationFailed(w, ValidationErrors{"todos": "At least one todo is required"})
+		return
+	}
+
+	// Validate the whole batch up front so nothing is inserted on failure
+	for i := range todos {
+		if err := todos[i].Validate(); err != nil {
+			app.respondErrorDetails(w, http.StatusUnprocessableEntity,
+				fmt.Sprintf("Todo at index %d is invalid: %s", i, err.Error()),
+				renderer.M{"index": i, "fields": err},
+			)
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	if !app.titlesAvailable(ctx, w, todos, nil) {
+		return
+	}
+
+	now := time.Now()
+	for i := range todos {
+		todos[i].prepareForInsert(now)
+	}
+
+	err := app.store.CreateMany(ctx, todos)
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "The batch contains a title that already exists")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to create todos")
+		return
+	}
+
+	app.respondJSON(w, http.StatusCreated, todos)
+}
+
+// todoReplace is the body of PUT /todos/{id}. It decodes as a Todo, so
+// clients may PUT back a todo they fetched, and records which fields were
+// sent with a value other than null.
+type todoReplace struct {
+	Todo
+	sent map[string]bool
+}
+
+func (t *todoReplace) UnmarshalJSON(data []byte) error {
+	if err := t.Todo.UnmarshalJSON(data); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	t.sent = make(map[string]bool, len(fields))
+	for name, raw := range fields {
+		t.sent[name] = string(raw) != "null"
+	}
+	return nil
+}
+
+// titlesAvailable checks a batch against the unique title index before it
+// is written, since an ordered write that hits the index would leave the
+// earlier items of the batch behind. The index only covers open todos; the
+// stored todos with an ID in except are replaced by the batch, so their
+// current titles do not count. It answers 409 itself when a title is taken,
+// and handlers return when ok is false.
+func (app *App) titlesAvailable(ctx context.Context, w http.ResponseWriter, todos []Todo, except []primitive.ObjectID) (ok bool) {
+	titles := make([]string, 0, len(todos))
+	seen := make(map[string]int, len(todos))
+	for i, todo := range todos {
+		if todo.Completed {
+			continue
+		}
+		if first, ok := seen[todo.Title]; ok {
+			app.respondErrorDetails(w, http.StatusConflict,
+				fmt.Sprintf("Todo at index %d has the same title as index %d", i, first),
+				renderer.M{"index": i},
+			)
+			return false
+		}
+		seen[todo.Title] = i
+		titles = append(titles, todo.Title)
+	}
+
+	existing, err := app.store.FirstWithTitle(ctx, titles, except)
+	if err == nil {
+		app.respondErrorDetails(w, http.StatusConflict,
+			fmt.Sprintf("Todo at index %d has a title that already exists", seen[existing.Title]),
+			renderer.M{"index": seen[existing.Title]},
+		)
+		return false
+	}
+	if err != errNotFound {
+		app.storeFailed(w, err, "Failed to check titles")
+		return false
+	}
+	return true
 }
 
 func (app *App) updateTodo(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	var todo todoReplace
+	if err := decodeJSON(w, r, &todo); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"updatedAt": now,
+	}
+
+	// An omitted title leaves the stored one untouched, an empty one is rejected
+	if todo.sent["title"] {
+		title := strings.TrimSpace(todo.Title)
+		if msg := validateTitle(title); msg != "" {
+			app.validationFailed(w, ValidationErrors{"title": msg})
+			return
+		}
+		set["title"] = title
+	}
+
+	// An omitted priority keeps the stored one
+	if todo.Priority != "" {
+		if msg := validatePriority(todo.Priority); msg != "" {
+			app.validationFailed(w, ValidationErrors{"priority": msg})
+			return
+		}
+		set["priority"] = todo.Priority
+		set["priorityRank"] = priorityRanks[todo.Priority]
+	}
+
+	// An omitted tags keeps the stored ones, [] clears them
+	if todo.Tags != nil {
+		set["tags"] = normalizeTags(todo.Tags)
+	}
+
+	// Subtasks work the same way and replace the whole list when given
+	if todo.Subtasks != nil {
+		subtasks, msg := normalizeSubtasks(todo.Subtasks)
+		if msg != "" {
+			app.validationFailed(w, ValidationErrors{"subtasks": msg})
+			return
+		}
+		set["subtasks"] = subtasks
+	}
+
+	update := bson.M{"$set": set}
+
+	// An omitted completed keeps the stored state and completedAt
+	if todo.sent["completed"] {
+		set["completed"] = todo.Completed
+		setCompletion(update, todo.Completed, now)
+	}
+
+	// An omitted dueDate keeps the stored one, PATCH with null clears it
+	if todo.DueDate != nil {
+		set["dueDate"] = *todo.DueDate
+	}
+
+	// An omitted recurrence keeps the stored one, "" stops the todo repeating
+	if todo.sent["recurrence"] {
+		if msg := validateRecurrence(todo.Recurrence); msg != "" {
+			app.validationFailed(w, ValidationErrors{"recurrence": msg})
+			return
+		}
+		set["recurrence"] = todo.Recurrence
+	}
+
+	ctx := r.Context()
+
+	// Sending back the version of a fetched todo rejects the update if
+	// someone else wrote it in the meantime
+	updated, err := app.store.Update(ctx, objID, todo.Version, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err == errStaleVersion {
+		app.respondError(w, http.StatusConflict, "The todo was modified by someone else, refetch it and retry")
+		return
+	}
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "A todo with that title already exists")
+		return
+	}
 	if err != nil {
-		app.renderer.JSON(w, http.StatusBadRequest, renderer.M{
-			"error": "Invalid ID format",
-		})
+		app.storeFailed(w, err, "Failed to update todo")
 		return
 	}
 
-	var todo Todo
-	if err := json.NewDecoder(r.Body).Decode(&todo); err != nil {
-		app.renderer.JSON(w, http.StatusBadRequest, renderer.M{
-			"error": "Invalid request body",
-		})
+	if justCompleted(updated, now) {
+		app.scheduleNext(ctx, updated, now)
+	}
+
+	if preferMinimal(r) {
+		writeMinimal(w, todoLocation(objID))
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, updated)
+}
+
+// setCompletion adds the completedAt change matching completed to update.
+// $min only sets completedAt when it is missing, so re-saving a completed
+// todo keeps its original completion time.
+func setCompletion(update bson.M, completed bool, now time.Time) {
+	if completed {
+		addUpdate(update, "$min", "completedAt", now)
+	} else {
+		addUpdate(update, "$unset", "completedAt", "")
+	}
+}
+
+// addUpdate sets field under the update operator op, creating the operator document if needed
+func addUpdate(update bson.M, op, field string, value interface{}) {
+	fields, ok := update[op].(bson.M)
+	if !ok {
+		fields = bson.M{}
+		update[op] = fields
+	}
+	fields[field] = value
+}
+
+func (app *App) toggleComplete(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
 		return
 	}
 
+	// An empty body flips the current state, {"completed": bool} sets it
+	var body struct {
+		Completed *bool `json:"completed"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil && err != io.EOF {
+		app.invalidBody(w, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	existing, err := app.store.Get(ctx, objID)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to fetch todo")
+		return
+	}
+
+	completed := !existing.Completed
+	if body.Completed != nil {
+		completed = *body.Completed
+	}
+
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"title":     todo.Title,
-			"completed": todo.Completed,
+			"completed": completed,
+			"updatedAt": now,
 		},
 	}
+	setCompletion(update, completed, now)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err = app.db.Collection("todos").UpdateOne(ctx, bson.M{"_id": objID}, update)
+	updated, err := app.store.Update(ctx, objID, 0, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "An open todo with that title already exists")
+		return
+	}
 	if err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to update todo",
-		})
+		app.storeFailed(w, err, "Failed to update todo")
 		return
 	}
 
-	app.renderer.JSON(w, http.StatusOK, renderer.M{
-		"message": "Todo updated successfully",
-	})
+	if justCompleted(updated, now) {
+		app.scheduleNext(ctx, updated, now)
+	}
+
+	app.respondJSON(w, http.StatusOK, updated)
 }
 
 func (app *App) deleteTodo(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	objID, err := primitive.ObjectIDFromHex(id)
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	soft, err := parseBoolParam("soft", r.URL.Query().Get("soft"))
 	if err != nil {
-		app.renderer.JSON(w, http.StatusBadRequest, renderer.M{
-			"error": "Invalid ID format",
-		})
+		app.respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx := r.Context()
+
+	if soft != nil && *soft {
+		app.softDeleteTodo(ctx, w, objID)
+		return
+	}
+
+	deleted, err := app.store.Delete(ctx, objID)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to delete todo")
+		return
+	}
+
+	// The deleted todo is kept in memory for a while so it can be undone
+	expires := time.Now().Add(undoTTL)
+	token := app.undo.save(deleted, expires)
 
-	_, err = app.db.Collection("todos").DeleteOne(ctx, bson.M{"_id": objID})
+	app.respondJSON(w, http.StatusOK, undoResponse(token, expires))
+}
+
+// softDeleteTodo archives a todo by setting deletedAt. The document is
+// kept, so its title stays taken until it is deleted for good.
+func (app *App) softDeleteTodo(ctx context.Context, w http.ResponseWriter, id primitive.ObjectID) {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"deletedAt": now,
+		"updatedAt": now,
+	}}
+
+	_, err := app.store.Update(ctx, id, 0, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
 	if err != nil {
-		app.renderer.JSON(w, http.StatusInternalServerError, renderer.M{
-			"error": "Failed to delete todo",
-		})
+		app.storeFailed(w, err, "Failed to delete todo")
 		return
 	}
 
-	app.renderer.JSON(w, http.StatusOK, renderer.M{
+	app.respondJSON(w, http.StatusOK, renderer.M{
 		"message": "Todo deleted successfully",
 	})
 }
+
+func (app *App) restoreTodo(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	update := bson.M{
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$unset": bson.M{"deletedAt": ""},
+	}
+
+	restored, err := app.store.Update(ctx, objID, 0, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to restore todo")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, restored)
+}
+
+func (app *App) deleteCompleted(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		app.respondError(w, http.StatusBadRequest, "Pass ?confirm=true to delete all completed todos")
+		return
+	}
+
+	ctx := r.Context()
+
+	deleted, err := app.store.DeleteMany(ctx, bson.M{"completed": true})
+	if err != nil {
+		app.storeFailed(w, err, "Failed to delete completed todos")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"deleted": deleted,
+	})
+}
+
+// deleteAllTodos wipes the collection, soft-deleted todos included, e.g. to
+// reset a test environment
+func (app *App) deleteAllTodos(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		app.respondError(w, http.StatusBadRequest, "Pass ?confirm=true to delete all todos")
+		return
+	}
+
+	ctx := r.Context()
+
+	deleted, err := app.store.DeleteMany(ctx, bson.M{})
+	if err != nil {
+		app.storeFailed(w, err, "Failed to delete todos")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"deleted": deleted,
+	})
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testOwner is the X-User-ID the test requests are sent with
+const testOwner = "alice"
+
+// testConfig is the configuration newTestServer builds the router from
+func testConfig() Config {
+	return Config{
+		DBTimeout:      5 * time.Second,
+		RequestTimeout: 5 * time.Second,
+		ReportTimeout:  5 * time.Second,
+		WriteTimeout:   10 * time.Second,
+	}
+}
+
+// newTestServer returns the full router of an App backed by store
+func newTestServer(store TodoStore) http.Handler {
+	return newTestServerConfig(store, testConfig())
+}
+
+// newTestServerConfig is newTestServer with the router built from cfg
+func newTestServerConfig(store TodoStore, cfg Config) http.Handler {
+	app := &App{
+		renderer:  renderer.New(),
+		store:     store,
+		dbTimeout: cfg.DBTimeout,
+		undo:      newUndoStore(undoTTL),
+	}
+	return app.routes(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// send makes a request as testOwner, with body sent as JSON unless empty
+func send(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(ownerHeader, testOwner)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// testEnvelope is envelope with Data left raw so tests decode it into the type they expect
+type testEnvelope struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Meta    json.RawMessage `json:"meta"`
+	Error   *string         `json:"error"`
+}
+
+// decodeResponse checks the status of rec and decodes its envelope, and its data into data if not nil
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, data interface{}) testEnvelope {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, status, rec.Body.String())
+	}
+	var env testEnvelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decoding envelope: %v, body: %s", err, rec.Body.String())
+	}
+	if data != nil {
+		if err := json.Unmarshal(env.Data, data); err != nil {
+			t.Fatalf("decoding data: %v, body: %s", err, rec.Body.String())
+		}
+	}
+	return env
+}
+
+// storedTodo returns an open todo of testOwner as the store would hold it
+func storedTodo(title string) Todo {
+	todo := Todo{Title: title, OwnerID: testOwner, Tags: []string{}, Subtasks: []Subtask{}}
+	todo.prepareForInsert(time.Now())
+	return todo
+}
+
+func TestCreateTodo(t *testing.T) {
+	store := newFakeStore()
+	h := newTestServer(store)
+
+	rec := send(h, http.MethodPost, "/api/v1/todos", `{"title": "  Buy milk  ", "tags": ["Home"]}`)
+	var created Todo
+	decodeResponse(t, rec, http.StatusCreated, &created)
+
+	if created.Title != "Buy milk" {
+		t.Errorf("title = %q, want it trimmed to %q", created.Title, "Buy milk")
+	}
+	if created.Priority != defaultPriority {
+		t.Errorf("priority = %q, want %q", created.Priority, defaultPriority)
+	}
+	if got, want := rec.Header().Get("Location"), todoLocation(created.ID); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+
+	stored, ok := store.todos[created.ID]
+	if !ok {
+		t.Fatal("created todo was not stored")
+	}
+	if stored.OwnerID != testOwner {
+		t.Errorf("stored owner = %q, want %q", stored.OwnerID, testOwner)
+	}
+	if len(stored.Tags) != 1 || stored.Tags[0] != "home" {
+		t.Errorf("stored tags = %v, want [home]", stored.Tags)
+	}
+}
+
+func TestCreateTodoErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+	}{
+		{"malformed body", `{"title": `, nil, http.StatusBadRequest},
+		{"unknown field", `{"title": "x", "colour": "red"}`, nil, http.StatusBadRequest},
+		{"missing title", `{"priority": "high"}`, nil, http.StatusUnprocessableEntity},
+		{"invalid priority", `{"title": "x", "priority": "urgent"}`, nil, http.StatusUnprocessableEntity},
+		{"duplicate title", `{"title": "Taken"}`, nil, http.StatusConflict},
+		{"store failure", `{"title": "x"}`, errors.New("connection reset"), http.StatusInternalServerError},
+		{"store timeout", `{"title": "x"}`, context.DeadlineExceeded, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore(storedTodo("Taken"))
+			store.err = tt.err
+			h := newTestServer(store)
+
+			env := decodeResponse(t, send(h, http.MethodPost, "/api/v1/todos", tt.body), tt.status, nil)
+			if env.Success || env.Error == nil {
+				t.Errorf("envelope = %+v, want a failure with an error message", env)
+			}
+			if len(store.todos) != 1 {
+				t.Errorf("store holds %d todos, want only the existing one", len(store.todos))
+			}
+		})
+	}
+}
+
+func TestGetTodo(t *testing.T) {
+	todo := storedTodo("Buy milk")
+	other := storedTodo("Someone else's")
+	other.OwnerID = "bob"
+	h := newTestServer(newFakeStore(todo, other))
+
+	var got Todo
+	decodeResponse(t, send(h, http.MethodGet, todoLocation(todo.ID), ""), http.StatusOK, &got)
+	if got.ID != todo.ID || got.Title != todo.Title {
+		t.Errorf("got %s %q, want %s %q", got.ID.Hex(), got.Title, todo.ID.Hex(), todo.Title)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"invalid id", "/api/v1/todos/not-an-id", http.StatusBadRequest},
+		{"unknown id", todoLocation(primitive.NewObjectID()), http.StatusNotFound},
+		{"other owner", todoLocation(other.ID), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decodeResponse(t, send(h, http.MethodGet, tt.path, ""), tt.status, nil)
+		})
+	}
+}
+
+func TestGetTodos(t *testing.T) {
+	open := storedTodo("Open")
+	done := storedTodo("Done")
+	done.Completed = true
+	deleted := storedTodo("Deleted")
+	deletedAt := time.Now()
+	deleted.DeletedAt = &deletedAt
+	h := newTestServer(newFakeStore(open, done, deleted))
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Done", "Open"}},
+		{"?completed=false", []string{"Open"}},
+		{"?completed=true", []string{"Done"}},
+		{"?q=OPE", []string{"Open"}},
+		{"?includeDeleted=true&sort=title", []string{"Deleted", "Done", "Open"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var todos []Todo
+			env := decodeResponse(t, send(h, http.MethodGet, "/api/v1/todos"+tt.query, ""), http.StatusOK, &todos)
+
+			titles := make([]string, len(todos))
+			for i, todo := range todos {
+				titles[i] = todo.Title
+			}
+			if strings.Join(titles, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("titles = %v, want %v", titles, tt.want)
+			}
+
+			var meta paginationMeta
+			if err := json.Unmarshal(env.Meta, &meta); err != nil {
+				t.Fatalf("decoding meta: %v", err)
+			}
+			if meta.Total != int64(len(tt.want)) {
+				t.Errorf("meta.total = %d, want %d", meta.Total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestGetTodosETag(t *testing.T) {
+	h := newTestServer(newFakeStore(storedTodo("Buy milk")))
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/todos", nil)
+		req.Header.Set(ownerHeader, testOwner)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with an ETag", first.Code, etag)
+	}
+
+	if rec := get("If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation: status = %d, body %q, want an empty 304", rec.Code, rec.Body.String())
+	}
+
+	// createdAgo is phrased in Spanish, so the body and its ETag differ
+	spanish := get("Accept-Language", "es")
+	if spanish.Code != http.StatusOK {
+		t.Fatalf("Accept-Language es: status = %d, want 200", spanish.Code)
+	}
+	if got := spanish.Header().Get("ETag"); got == etag {
+		t.Errorf("Accept-Language es: ETag = %q, want it to differ from the English body's", got)
+	}
+}
+
+func TestGetTodosErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		err    error
+		status int
+	}{
+		{"invalid completed", "?completed=yes", nil, http.StatusBadRequest},
+		{"invalid sort", "?sort=colour", nil, http.StatusBadRequest},
+		{"invalid after", "?after=123", nil, http.StatusBadRequest},
+		{"unknown after", "?after=" + primitive.NewObjectID().Hex(), nil, http.StatusBadRequest},
+		{"store failure", "", errors.New("connection reset"), http.StatusInternalServerError},
+		{"store timeout", "", context.DeadlineExceeded, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore(storedTodo("Open"))
+			store.err = tt.err
+			h := newTestServer(store)
+
+			decodeResponse(t, send(h, http.MethodGet, "/api/v1/todos"+tt.query, ""), tt.status, nil)
+		})
+	}
+}
+
+func TestDeleteTodo(t *testing.T) {
+	todo := storedTodo("Buy milk")
+	store := newFakeStore(todo)
+	h := newTestServer(store)
+
+	var body struct {
+		UndoToken string `json:"undoToken"`
+	}
+	decodeResponse(t, send(h, http.MethodDelete, todoLocation(todo.ID), ""), http.StatusOK, &body)
+	if body.UndoToken == "" {
+		t.Error("response has no undo token")
+	}
+	if _, ok := store.todos[todo.ID]; ok {
+		t.Error("todo is still stored")
+	}
+
+	decodeResponse(t, send(h, http.MethodDelete, todoLocation(todo.ID), ""), http.StatusNotFound, nil)
+}
+
+func TestRequiresOwner(t *testing.T) {
+	h := newTestServer(newFakeStore())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/todos", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	decodeResponse(t, rec, http.StatusUnauthorized, nil)
+}
+
+func TestUpdateTodoKeepsOmittedTitle(t *testing.T) {
+	todo := storedTodo("Buy milk")
+	store := newFakeStore(todo)
+	h := newTestServer(store)
+
+	var updated Todo
+	decodeResponse(t, send(h, http.MethodPut, todoLocation(todo.ID), `{"completed": true}`), http.StatusOK, &updated)
+
+	if updated.Title != "Buy milk" {
+		t.Errorf("title = %q, want the stored %q", updated.Title, "Buy milk")
+	}
+	if !updated.Completed || updated.CompletedAt == nil {
+		t.Errorf("completed = %v, completedAt = %v, want it completed", updated.Completed, updated.CompletedAt)
+	}
+	if stored := store.todos[todo.ID]; stored.Title != "Buy milk" {
+		t.Errorf("stored title = %q, want %q", stored.Title, "Buy milk")
+	}
+}
+
+func TestUpdateTodoKeepsOmittedCompleted(t *testing.T) {
+	todo := storedTodo("Water plants")
+	todo.Recurrence = "daily"
+	completedAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	todo.Completed = true
+	todo.CompletedAt = &completedAt
+	store := newFakeStore(todo)
+	h := newTestServer(store)
+
+	var updated Todo
+	decodeResponse(t, send(h, http.MethodPut, todoLocation(todo.ID), `{"recurrence": "weekly"}`), http.StatusOK, &updated)
+
+	if !updated.Completed {
+		t.Error("completed = false, want the stored completion kept")
+	}
+	if updated.CompletedAt == nil || !updated.CompletedAt.Equal(completedAt) {
+		t.Errorf("completedAt = %v, want the stored %v", updated.CompletedAt, completedAt)
+	}
+	if updated.Recurrence != "weekly" {
+		t.Errorf("recurrence = %q, want %q", updated.Recurrence, "weekly")
+	}
+	// Nothing was completed, so no next occurrence is scheduled
+	if len(store.todos) != 1 {
+		t.Errorf("store holds %d todos, want 1", len(store.todos))
+	}
+
+	var reopened Todo
+	decodeResponse(t, send(h, http.MethodPut, todoLocation(todo.ID), `{"completed": false}`), http.StatusOK, &reopened)
+	if reopened.Completed || reopened.CompletedAt != nil {
+		t.Errorf("completed = %v, completedAt = %v, want it reopened", reopened.Completed, reopened.CompletedAt)
+	}
+}
+
+func TestUpdateTodoTitle(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"new title", `{"title": "  Buy oat milk "}`, http.StatusOK, "Buy oat milk"},
+		{"null title", `{"title": null}`, http.StatusOK, "Buy milk"},
+		{"empty title", `{"title": ""}`, http.StatusUnprocessableEntity, "Buy milk"},
+		{"blank title", `{"title": "   "}`, http.StatusUnprocessableEntity, "Buy milk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo := storedTodo("Buy milk")
+			store := newFakeStore(todo)
+			h := newTestServer(store)
+
+			decodeResponse(t, send(h, http.MethodPut, todoLocation(todo.ID), tt.body), tt.status, nil)
+			if got := store.todos[todo.ID].Title; got != tt.want {
+				t.Errorf("stored title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseObjectID(t *testing.T) {
+	valid := primitive.NewObjectID()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    primitive.ObjectID
+		wantErr bool
+	}{
+		{"valid", valid.Hex(), valid, false},
+		{"uppercase", strings.ToUpper(valid.Hex()), valid, false},
+		{"surrounding whitespace", " " + valid.Hex() + "\n", valid, false},
+		{"empty", "", primitive.NilObjectID, true},
+		{"whitespace only", "   ", primitive.NilObjectID, true},
+		{"too short", valid.Hex()[:23], primitive.NilObjectID, true},
+		{"too long", valid.Hex() + "0", primitive.NilObjectID, true},
+		{"not hex", "zzzzzzzzzzzzzzzzzzzzzzzz", primitive.NilObjectID, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseObjectID(tt.input)
+			if tt.wantErr {
+				if err != errInvalidID {
+					t.Errorf("parseObjectID(%q) error = %v, want errInvalidID", tt.input, err)
+				}
+			} else if err != nil {
+				t.Errorf("parseObjectID(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseObjectID(%q) = %s, want %s", tt.input, got.Hex(), tt.want.Hex())
+			}
+		})
+	}
+}
+
+func TestIDParam(t *testing.T) {
+	valid := primitive.NewObjectID()
+
+	tests := []struct {
+		name   string
+		param  string
+		wantOK bool
+	}{
+		{"valid", valid.Hex(), true},
+		{"surrounding whitespace", " " + valid.Hex() + " ", true},
+		{"empty", "", false},
+		{"whitespace only", "  ", false},
+		{"wrong length", valid.Hex()[:12], false},
+		{"not hex", strings.Repeat("g", 24), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Set the param directly so values a URL cannot carry are covered too
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.param)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			rec := httptest.NewRecorder()
+
+			app := &App{renderer: renderer.New()}
+			id, ok := app.idParam(rec, req)
+
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok {
+				if id != valid {
+					t.Errorf("id = %s, want %s", id.Hex(), valid.Hex())
+				}
+				if rec.Body.Len() != 0 {
+					t.Errorf("wrote %q, want nothing written for a valid ID", rec.Body.String())
+				}
+				return
+			}
+
+			env := decodeResponse(t, rec, http.StatusBadRequest, nil)
+			if env.Error == nil || *env.Error != "Invalid ID format" {
+				t.Errorf("error = %v, want %q", env.Error, "Invalid ID format")
+			}
+			if id != primitive.NilObjectID {
+				t.Errorf("id = %s, want the nil ObjectID", id.Hex())
+			}
+		})
+	}
+}
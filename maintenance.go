@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// maintenanceGate rejects mutating requests with 503 while maintenance mode
+// is on. Reads keep working so clients can still display data.
+func (app *App) maintenanceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if app.maintenance.Load() {
+				app.respondError(w, http.StatusServiceUnavailable, "The API is in maintenance mode, please try again later")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminToken only lets through requests whose X-Admin-Token header matches token
+func (app *App) requireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get("X-Admin-Token")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				app.respondError(w, http.StatusUnauthorized, "Invalid admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (app *App) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"enabled": app.maintenance.Load(),
+	})
+}
+
+func (app *App) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil || body.Enabled == nil {
+		app.respondError(w, http.StatusBadRequest, "Body must be {\"enabled\": true|false}")
+		return
+	}
+
+	app.maintenance.Store(*body.Enabled)
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"enabled": *body.Enabled,
+	})
+}
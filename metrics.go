@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "todo_http_requests_total",
+		Help: "HTTP requests by method, matched route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "todo_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and matched route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	todosTotal = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "todo_todos",
+		Help: "Todos that are not soft deleted, refreshed periodically.",
+	})
+)
+
+// instrument records every request in httpRequests and httpDuration. Routes
+// are labeled by chi pattern, such as /api/v1/todos/{id}, so IDs do not
+// create a series each.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		// The pattern is only known once the router has matched the request
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// trackTodoCount refreshes the todosTotal gauge every interval until ctx is done
+func (app *App) trackTodoCount(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		countCtx, cancel := context.WithTimeout(ctx, app.dbTimeout)
+		total, err := app.store.Count(countCtx, notDeletedCondition())
+		cancel()
+		if err != nil {
+			slog.Warn("Failed to refresh todo count metric", "error", err.Error())
+		} else {
+			todosTotal.Set(float64(total))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
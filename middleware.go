@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestLogger logs one structured entry per request carrying the
+// middleware.RequestID value. Requests with a 4xx/5xx status are always
+// logged, successful ones with probability rate (0 to 1).
+func requestLogger(logger *slog.Logger, rate float64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					// Nothing was written, net/http sends 200
+					status = http.StatusOK
+				}
+				if status < http.StatusBadRequest && rand.Float64() >= rate {
+					return
+				}
+
+				level := slog.LevelInfo
+				if status >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+				logger.LogAttrs(r.Context(), level, "request",
+					slog.String("request_id", middleware.GetReqID(r.Context())),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", status),
+					slog.Int("bytes", ww.BytesWritten()),
+					slog.Duration("latency", time.Since(start)),
+					slog.String("remote_addr", r.RemoteAddr),
+				)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// skipPaths applies mw to every request except those for the given paths,
+// e.g. to keep health probes out of the request log
+func skipPaths(mw func(http.Handler) http.Handler, paths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, path := range paths {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsMethods and corsHeaders are what cross-origin API callers may use
+const (
+	corsMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsHeaders = "Accept, Authorization, Content-Type, If-None-Match, Prefer, X-User-ID"
+)
+
+// cors allows browser requests from the given origins and answers their
+// OPTIONS preflights. "*" allows any origin. Requests from other origins
+// get no CORS headers, so browsers block them; with no origins configured
+// every cross-origin request is blocked.
+func cors(origins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Responses differ by Origin whether or not it is allowed
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(allowed[origin] || allowed["*"]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Location")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// splitList parses a comma-separated environment value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// writeDeadline replaces the server's WriteTimeout for the routes it wraps,
+// so routes allowed to run longer than it are not cut off. Zero removes the
+// deadline.
+func writeDeadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			if d > 0 {
+				deadline = time.Now().Add(d)
+			}
+			http.NewResponseController(w).SetWriteDeadline(deadline)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// dbDeadline bounds the request context by d, the DB_TIMEOUT, so the
+// database calls of the handlers it wraps share one deadline and, like the
+// request context, are canceled when the client goes away
+func dbDeadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDBDeadlineCanceledWithRequest(t *testing.T) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newFakeStore()
+	var sawDeadline, sawDone bool
+	store.onCall = func(ctx context.Context) {
+		_, sawDeadline = ctx.Deadline()
+		// The client goes away while the handler is in the store
+		cancel()
+		select {
+		case <-ctx.Done():
+			sawDone = true
+		case <-time.After(time.Second):
+		}
+	}
+	h := newTestServer(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/todos", nil).WithContext(reqCtx)
+	req.Header.Set(ownerHeader, testOwner)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !sawDeadline {
+		t.Error("store context has no deadline")
+	}
+	if !sawDone {
+		t.Error("store context was not done after the request context was canceled")
+	}
+}
+
+func TestDBDeadlineExpires(t *testing.T) {
+	cfg := testConfig()
+	cfg.DBTimeout = 20 * time.Millisecond
+
+	store := newFakeStore()
+	var remaining time.Duration
+	store.onCall = func(ctx context.Context) {
+		deadline, _ := ctx.Deadline()
+		remaining = time.Until(deadline)
+		// A database that does not answer
+		<-ctx.Done()
+	}
+	h := newTestServerConfig(store, cfg)
+
+	rec := send(h, http.MethodGet, "/api/v1/todos", "")
+
+	if remaining <= 0 || remaining > cfg.DBTimeout {
+		t.Errorf("deadline %v away, want within DB_TIMEOUT %v", remaining, cfg.DBTimeout)
+	}
+	decodeResponse(t, rec, http.StatusGatewayTimeout, nil)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migration is a one-off schema change applied at startup. Each run function
+// must be idempotent: if the process dies between running it and recording
+// it, it will be run again on the next start. A run that fails is not
+// recorded, so it is retried on the next start too.
+type migration struct {
+	id  string
+	run func(ctx context.Context, todos *mongo.Collection, cfg Config) error
+}
+
+// migrations are applied in order and tracked by id in the migrations
+// collection kept alongside the todos collection, see companionCollection. Append new entries; never reorder or rename existing ones.
+var migrations = []migration{
+	{
+		id: "0001_backfill_completed",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"completed": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"completed": false}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0002_backfill_created_at",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			// ObjectIDs embed their creation time, which is the best
+			// guess we have for documents written without createdAt
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"createdAt": bson.M{"$exists": false}},
+				bson.A{bson.M{"$set": bson.M{"createdAt": bson.M{"$toDate": "$_id"}}}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0003_backfill_priority",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"priority": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"priority": defaultPriority, "priorityRank": priorityRanks[defaultPriority]}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0004_backfill_updated_at",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			// Treat todos written before updatedAt existed as unmodified
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"updatedAt": bson.M{"$exists": false}},
+				bson.A{bson.M{"$set": bson.M{"updatedAt": "$createdAt"}}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0005_backfill_tags",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"tags": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"tags": bson.A{}}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0006_backfill_version",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"version": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"version": 1}},
+			)
+			return err
+		},
+	},
+	{
+		id: "0007_backfill_subtasks",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			_, err := todos.UpdateMany(ctx,
+				bson.M{"subtasks": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"subtasks": bson.A{}}},
+			)
+			return err
+		},
+	},
+	{
+		// Replaced by title_open_unique, see todoIndexes
+		id: "0008_drop_title_unique",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			return dropIndex(ctx, todos, "title_unique")
+		},
+	},
+	{
+		// Replaced by owner_title_open_unique, see todoIndexes
+		id: "0009_drop_title_open_unique",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			return dropIndex(ctx, todos, "title_open_unique")
+		},
+	},
+	{
+		// Todos and snapshots written before X-User-ID existed have no owner
+		// and would be invisible to every user, so they go to
+		// DEFAULT_OWNER_ID. Without it the migration fails, stopping startup
+		// until it is set.
+		id: "0010_assign_owner",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			ownerless := bson.M{"ownerId": bson.M{"$in": bson.A{nil, ""}}}
+			for _, collection := range []*mongo.Collection{todos, companionCollection(todos, "snapshots")} {
+				if cfg.DefaultOwnerID != "" {
+					_, err := collection.UpdateMany(ctx, ownerless, bson.M{"$set": bson.M{"ownerId": cfg.DefaultOwnerID}})
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				count, err := collection.CountDocuments(ctx, ownerless)
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					return fmt.Errorf("%d documents in %s have no owner, set DEFAULT_OWNER_ID to the X-User-ID they should belong to", count, collection.Name())
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Snapshots used to hold their todos inline, which capped a snapshot
+		// at 16 MB. Move them to snapshot_items, see snapshotItem. Items
+		// left by an interrupted run are replaced.
+		id: "0011_snapshot_items",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			snapshots := companionCollection(todos, "snapshots")
+			items := companionCollection(todos, "snapshot_items")
+
+			cursor, err := snapshots.Find(ctx, bson.M{"todos": bson.M{"$exists": true}})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var old struct {
+					ID    primitive.ObjectID `bson:"_id"`
+					Todos []Todo             `bson:"todos"`
+				}
+				if err := cursor.Decode(&old); err != nil {
+					return err
+				}
+
+				if _, err := items.DeleteMany(ctx, bson.M{"snapshotId": old.ID}); err != nil {
+					return err
+				}
+
+				batch := make([]interface{}, 0, snapshotBatchSize)
+				var count int64
+				for i, todo := range old.Todos {
+					if todo.DeletedAt == nil {
+						batch = append(batch, snapshotItem{SnapshotID: old.ID, Todo: todo})
+					}
+					if len(batch) == 0 || (len(batch) < snapshotBatchSize && i < len(old.Todos)-1) {
+						continue
+					}
+					if _, err := items.InsertMany(ctx, batch); err != nil {
+						return err
+					}
+					count += int64(len(batch))
+					batch = batch[:0]
+				}
+
+				_, err := snapshots.UpdateByID(ctx, old.ID, bson.M{
+					"$set":   bson.M{"count": count},
+					"$unset": bson.M{"todos": ""},
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return cursor.Err()
+		},
+	},
+	{
+		// The owner_name_unique index cannot be built while names repeat,
+		// which the old check-then-insert allowed under races. The oldest
+		// snapshot keeps the name, later ones get their ID appended.
+		id: "0012_rename_duplicate_snapshots",
+		run: func(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+			snapshots := companionCollection(todos, "snapshots")
+
+			cursor, err := snapshots.Aggregate(ctx, bson.A{
+				bson.M{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+				bson.M{"$group": bson.M{
+					"_id":  bson.M{"ownerId": "$ownerId", "name": "$name"},
+					"ids":  bson.M{"$push": "$_id"},
+					"name": bson.M{"$first": "$name"},
+				}},
+				bson.M{"$match": bson.M{"ids.1": bson.M{"$exists": true}}},
+			})
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var group struct {
+					IDs  []primitive.ObjectID `bson:"ids"`
+					Name string               `bson:"name"`
+				}
+				if err := cursor.Decode(&group); err != nil {
+					return err
+				}
+				for _, id := range group.IDs[1:] {
+					_, err := snapshots.UpdateByID(ctx, id, bson.M{"$set": bson.M{"name": group.Name + " " + id.Hex()}})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return cursor.Err()
+		},
+	},
+}
+
+// dropIndex drops the named index, doing nothing if it or the collection does not exist
+func dropIndex(ctx context.Context, todos *mongo.Collection, name string) error {
+	_, err := todos.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return err
+}
+
+// migrationRecord is stored in the migrations collection once a migration has run
+type migrationRecord struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+func runMigrations(ctx context.Context, todos *mongo.Collection, cfg Config) error {
+	applied := companionCollection(todos, "migrations")
+
+	for _, m := range migrations {
+		count, err := applied.CountDocuments(ctx, bson.M{"_id": m.id})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := m.run(ctx, todos, cfg); err != nil {
+			return fmt.Errorf("migration %s: %w", m.id, err)
+		}
+
+		_, err = applied.InsertOne(ctx, migrationRecord{ID: m.id, AppliedAt: time.Now()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		log.Printf("Applied migration %s", m.id)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ownerHeader identifies the user a request acts for
+const ownerHeader = "X-User-ID"
+
+type ownerKey struct{}
+
+// withOwner returns ctx scoped to the todos of ownerID
+func withOwner(ctx context.Context, ownerID string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, ownerID)
+}
+
+// ownerFromContext returns the owner set by requireOwner. Background work
+// such as the metrics gauge has none and sees every todo.
+func ownerFromContext(ctx context.Context) (string, bool) {
+	ownerID, ok := ctx.Value(ownerKey{}).(string)
+	return ownerID, ok
+}
+
+// requireOwner answers 401 to requests without an X-User-ID header and
+// scopes the context of the others to that user, which MongoTodoStore
+// applies to every query
+func (app *App) requireOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ownerID := strings.TrimSpace(r.Header.Get(ownerHeader))
+		if ownerID == "" {
+			app.respondError(w, http.StatusUnauthorized, "Missing "+ownerHeader+" header")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withOwner(r.Context(), ownerID)))
+	})
+}
+
+// scope restricts filter to the owner in ctx, if any
+func scope(ctx context.Context, filter bson.M) bson.M {
+	ownerID, ok := ownerFromContext(ctx)
+	if !ok {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"ownerId": ownerID}}}
+}
+
+// scopePipeline prepends a $match for the owner in ctx, if any, to an
+// aggregation pipeline
+func scopePipeline(ctx context.Context, pipeline bson.A) bson.A {
+	ownerID, ok := ownerFromContext(ctx)
+	if !ok {
+		return pipeline
+	}
+	return append(bson.A{bson.M{"$match": bson.M{"ownerId": ownerID}}}, pipeline...)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pagination is a parsed ?page=&limit= pair, or ?after=&limit= for keyset
+// pagination. after takes precedence over page.
+type pagination struct {
+	page  int
+	limit int
+	after primitive.ObjectID
+}
+
+// parsePagination reads page, limit and after from the query. Missing,
+// invalid or negative page and limit values fall back to the defaults and
+// limit is capped at maxPageLimit; an invalid after is an error.
+func parsePagination(query url.Values) (pagination, error) {
+	p := pagination{page: 1, limit: defaultPageLimit}
+
+	if after := query.Get("after"); after != "" {
+		id, err := parseObjectID(after)
+		if err != nil {
+			return p, errors.New("after must be a todo ID")
+		}
+		p.page = 0
+		p.after = id
+	}
+
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 0 {
+		p.page = page
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		p.limit = limit
+	}
+	if p.limit > maxPageLimit {
+		p.limit = maxPageLimit
+	}
+	return p, nil
+}
+
+func (p pagination) keyset() bool {
+	return !p.after.IsZero()
+}
+
+func (p pagination) skip() int64 {
+	if p.keyset() {
+		return 0
+	}
+	return int64(p.page-1) * int64(p.limit)
+}
+
+// paginationMeta describes where a page sits in the full result set. Page
+// and TotalPages are left out of keyset pages. NextCursor is the ?after=
+// value for the following page and is empty on the last one.
+type paginationMeta struct {
+	Total      int64  `json:"total"`
+	Page       *int   `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	TotalPages *int64 `json:"totalPages,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func (p pagination) meta(total int64, todos []Todo) paginationMeta {
+	meta := paginationMeta{
+		Total: total,
+		Limit: p.limit,
+	}
+	if !p.keyset() {
+		totalPages := (total + int64(p.limit) - 1) / int64(p.limit)
+		meta.Page = &p.page
+		meta.TotalPages = &totalPages
+	}
+	// A short page is the last one, a full page may be followed by more
+	if len(todos) == p.limit {
+		meta.NextCursor = todos[len(todos)-1].ID.Hex()
+	}
+	return meta
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// patchField is a field of a PATCH body that records whether it was sent,
+// so an omitted field can be told apart from one sent as null
+type patchField[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (f *patchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		return nil
+	}
+	f.Value = new(T)
+	return unmarshalStrict(data, f.Value)
+}
+
+// todoPatch is the body of PATCH /todos/{id}. Only the fields it contains
+// are changed; dueDate and recurrence may be null to clear them.
+type todoPatch struct {
+	Title      patchField[string]    `json:"title"`
+	Completed  patchField[bool]      `json:"completed"`
+	DueDate    patchField[timeInput] `json:"dueDate"`
+	Priority   patchField[string]    `json:"priority"`
+	Tags       patchField[[]string]  `json:"tags"`
+	Subtasks   patchField[[]Subtask] `json:"subtasks"`
+	Recurrence patchField[string]    `json:"recurrence"`
+	Version    int                   `json:"version"`
+}
+
+// UnmarshalJSON decodes the fields one at a time, since type errors raised
+// inside a patchField would otherwise not say which field they are about
+func (p *todoPatch) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	targets := map[string]interface{}{
+		"title":      &p.Title,
+		"completed":  &p.Completed,
+		"dueDate":    &p.DueDate,
+		"priority":   &p.Priority,
+		"tags":       &p.Tags,
+		"subtasks":   &p.Subtasks,
+		"recurrence": &p.Recurrence,
+		"version":    &p.Version,
+	}
+	for name, raw := range fields {
+		target, ok := targets[name]
+		if !ok {
+			// Worded like encoding/json so invalidBody recognizes it
+			return fmt.Errorf("json: unknown field %q", name)
+		}
+		if err := unmarshalStrict(raw, target); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				typeErr.Field = strings.TrimSuffix(name+"."+typeErr.Field, ".")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalStrict is json.Unmarshal rejecting unknown fields, as decodeJSON does
+func unmarshalStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// update builds the $set and $unset for the fields in the patch, or returns
+// ValidationErrors for those that cannot be stored
+func (p todoPatch) update(now time.Time) (bson.M, error) {
+	errs := ValidationErrors{}
+	set := bson.M{"updatedAt": now}
+	update := bson.M{"$set": set}
+
+	notNull := func(field string, f bool) bool {
+		if !f {
+			errs[field] = strings.ToUpper(field[:1]) + field[1:] + " cannot be null"
+		}
+		return f
+	}
+
+	if p.Title.Set && notNull("title", p.Title.Value != nil) {
+		title := strings.TrimSpace(*p.Title.Value)
+		if msg := validateTitle(title); msg != "" {
+			errs["title"] = msg
+		}
+		set["title"] = title
+	}
+
+	if p.Completed.Set && notNull("completed", p.Completed.Value != nil) {
+		set["completed"] = *p.Completed.Value
+		setCompletion(update, *p.Completed.Value, now)
+	}
+
+	if p.DueDate.Set {
+		if p.DueDate.Value != nil {
+			set["dueDate"] = time.Time(*p.DueDate.Value)
+		} else {
+			addUpdate(update, "$unset", "dueDate", "")
+		}
+	}
+
+	// Unlike on create, an empty priority is not read as the default
+	if p.Priority.Set && notNull("priority", p.Priority.Value != nil) {
+		priority := *p.Priority.Value
+		if priorityRanks[priority] == 0 {
+			errs["priority"] = "Priority must be one of low, medium, high"
+		}
+		set["priority"] = priority
+		set["priorityRank"] = priorityRanks[priority]
+	}
+
+	if p.Tags.Set && notNull("tags", p.Tags.Value != nil) {
+		set["tags"] = normalizeTags(*p.Tags.Value)
+	}
+
+	if p.Subtasks.Set && notNull("subtasks", p.Subtasks.Value != nil) {
+		subtasks, msg := normalizeSubtasks(*p.Subtasks.Value)
+		if msg != "" {
+			errs["subtasks"] = msg
+		}
+		set["subtasks"] = subtasks
+	}
+
+	if p.Recurrence.Set {
+		var recurrence string
+		if p.Recurrence.Value != nil {
+			recurrence = *p.Recurrence.Value
+		}
+		if msg := validateRecurrence(recurrence); msg != "" {
+			errs["recurrence"] = msg
+		}
+		set["recurrence"] = recurrence
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return update, nil
+}
+
+func (app *App) patchTodo(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	var patch todoPatch
+	if err := decodeJSON(w, r, &patch); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	now := time.Now()
+	update, err := patch.update(now)
+	if err != nil {
+		app.validationFailed(w, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	updated, err := app.store.Update(ctx, objID, patch.Version, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err == errStaleVersion {
+		app.respondError(w, http.StatusConflict, "The todo was modified by someone else, refetch it and retry")
+		return
+	}
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "A todo with that title already exists")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to update todo")
+		return
+	}
+
+	if justCompleted(updated, now) {
+		app.scheduleNext(ctx, updated, now)
+	}
+
+	if preferMinimal(r) {
+		writeMinimal(w, todoLocation(objID))
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, updated)
+}
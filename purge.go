@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// purgeCompleted deletes, every interval until ctx is done, the completed
+// todos last updated more than after ago. Owners do not apply, ctx has none,
+// so it purges across all users.
+func (app *App) purgeCompleted(ctx context.Context, interval, after time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-after)
+		purgeCtx, cancel := context.WithTimeout(ctx, app.dbTimeout)
+		deleted, err := app.store.DeleteMany(purgeCtx, bson.M{
+			"completed": true,
+			"updatedAt": bson.M{"$lt": cutoff},
+		})
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to purge completed todos", "error", err.Error())
+			}
+			continue
+		}
+		slog.Info("Purged completed todos", "deleted", deleted, "cutoff", cutoff)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client IP. Each bucket holds up to one
+// minute's worth of requests and refills continuously.
+type rateLimiter struct {
+	perSecond float64
+	burst     float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows perMinute requests per minute per IP and starts a
+// goroutine that drops buckets of clients that have gone quiet
+func newRateLimiter(perMinute int) *rateLimiter {
+	l := &rateLimiter{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(perMinute),
+		buckets:   map[string]*bucket{},
+	}
+	go l.cleanup(time.Minute)
+	return l
+}
+
+// allow takes a token from key's bucket, returning how long to wait when it is empty
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup periodically removes buckets that have refilled completely, since
+// a fresh bucket behaves the same
+func (l *rateLimiter) cleanup(interval time.Duration) {
+	full := time.Duration(l.burst / l.perSecond * float64(time.Second))
+	for now := range time.Tick(interval) {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// limitRate answers 429 with Retry-After once a client IP runs out of
+// tokens. It relies on middleware.RealIP having set RemoteAddr.
+func (app *App) limitRate(l *rateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(ip); err == nil {
+				ip = host
+			}
+
+			if ok, wait := l.allow(ip, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				app.respondError(w, http.StatusTooManyRequests, "Too many requests, please slow down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Recurrence values a todo may have; empty means it does not repeat
+const (
+	recurrenceDaily   = "daily"
+	recurrenceWeekly  = "weekly"
+	recurrenceMonthly = "monthly"
+)
+
+// validateRecurrence returns why recurrence is unacceptable, or "" when it is valid
+func validateRecurrence(recurrence string) string {
+	switch recurrence {
+	case "", recurrenceDaily, recurrenceWeekly, recurrenceMonthly:
+		return ""
+	}
+	return "Recurrence must be one of daily, weekly, monthly"
+}
+
+// nextDueDate advances due by one recurrence interval. Monthly keeps the
+// day of the month unless the next month is shorter, in which case it lands
+// on that month's last day: Jan 31 is followed by Feb 28 (29 in leap years).
+// The clamped day carries on, so the occurrence after Feb 28 is Mar 28.
+func nextDueDate(due time.Time, recurrence string) time.Time {
+	switch recurrence {
+	case recurrenceDaily:
+		return due.AddDate(0, 0, 1)
+	case recurrenceWeekly:
+		return due.AddDate(0, 0, 7)
+	}
+
+	year, month, day := due.Date()
+	// Day 0 of the month after next is the last day of next month
+	if last := time.Date(year, month+2, 0, 0, 0, 0, 0, due.Location()).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month+1, day, due.Hour(), due.Minute(), due.Second(), due.Nanosecond(), due.Location())
+}
+
+// justCompleted reports whether the write made at now completed todo.
+// setCompletion only moves completedAt when a todo becomes completed, and
+// stored times have millisecond precision.
+func justCompleted(todo Todo, now time.Time) bool {
+	return todo.Recurrence != "" && todo.Completed && todo.CompletedAt != nil &&
+		todo.CompletedAt.Equal(now.Truncate(time.Millisecond))
+}
+
+// scheduleNext creates the next occurrence of a recurring todo that was just
+// completed, leaving the completed one as a record. A todo without a due
+// date is scheduled one interval after it was completed. Failures are logged
+// rather than failing the completion, which has already been stored.
+func (app *App) scheduleNext(ctx context.Context, completed Todo, now time.Time) {
+	due := now
+	if completed.DueDate != nil {
+		due = *completed.DueDate
+	}
+	due = nextDueDate(due, completed.Recurrence)
+
+	subtasks := make([]Subtask, len(completed.Subtasks))
+	for i, subtask := range completed.Subtasks {
+		subtasks[i] = Subtask{Title: subtask.Title}
+	}
+
+	next := Todo{
+		Title:      completed.Title,
+		DueDate:    &due,
+		Priority:   completed.Priority,
+		Tags:       completed.Tags,
+		Subtasks:   subtasks,
+		Recurrence: completed.Recurrence,
+	}
+	next.prepareForInsert(now)
+
+	// Titles are unique among open todos, so a duplicate means the next
+	// occurrence exists already, e.g. after reopening and completing again
+	err := app.store.Create(ctx, next)
+	if err != nil && err != errDuplicateTitle {
+		slog.Error("Failed to schedule next occurrence",
+			"todo_id", completed.ID.Hex(),
+			"error", err.Error(),
+		)
+	}
+}
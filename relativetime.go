@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// relativeUnit is one step of the relative time scale, e.g. hours
+type relativeUnit struct {
+	size time.Duration
+	key  string
+}
+
+// relativeUnits is ordered largest first; months and years use fixed
+// 30 and 365 day lengths, which is close enough for a human summary
+var relativeUnits = []relativeUnit{
+	{365 * 24 * time.Hour, "year"},
+	{30 * 24 * time.Hour, "month"},
+	{24 * time.Hour, "day"},
+	{time.Hour, "hour"},
+	{time.Minute, "minute"},
+}
+
+// relativeLocale holds the words needed to phrase "N units ago" in one language
+type relativeLocale struct {
+	justNow string
+	ago     string // format with the count and unit, e.g. "%d %s ago"
+	units   map[string][2]string
+}
+
+var relativeLocales = map[string]relativeLocale{
+	"en": {
+		justNow: "just now",
+		ago:     "%d %s ago",
+		units: map[string][2]string{
+			"year":   {"year", "years"},
+			"month":  {"month", "months"},
+			"day":    {"day", "days"},
+			"hour":   {"hour", "hours"},
+			"minute": {"minute", "minutes"},
+		},
+	},
+	"es": {
+		justNow: "justo ahora",
+		ago:     "hace %d %s",
+		units: map[string][2]string{
+			"year":   {"año", "años"},
+			"month":  {"mes", "meses"},
+			"day":    {"día", "días"},
+			"hour":   {"hora", "horas"},
+			"minute": {"minuto", "minutos"},
+		},
+	},
+}
+
+// localeFromAcceptLanguage returns the first supported language listed in an
+// Accept-Language header, falling back to English
+func localeFromAcceptLanguage(header string) relativeLocale {
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if locale, ok := relativeLocales[lang]; ok {
+			return locale
+		}
+	}
+	return relativeLocales["en"]
+}
+
+// since phrases the time elapsed between t and now, e.g. "2 hours ago".
+// Times in the future, from client clock skew, are reported as just now.
+func (l relativeLocale) since(t, now time.Time) string {
+	elapsed := now.Sub(t)
+	for _, unit := range relativeUnits {
+		if elapsed < unit.size {
+			continue
+		}
+		n := int(elapsed / unit.size)
+		words := l.units[unit.key]
+		word := words[1]
+		if n == 1 {
+			word = words[0]
+		}
+		return fmt.Sprintf(l.ago, n, word)
+	}
+	return l.justNow
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	// Embed the zone database so ?tz= works on hosts without one installed
+	_ "time/tzdata"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dailyCount is the number of todos completed on one calendar day
+type dailyCount struct {
+	Date  string `json:"date" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// trend compares two averages for a coarse up/down/flat indicator
+func trend(current, previous float64) string {
+	switch {
+	case current > previous:
+		return "up"
+	case current < previous:
+		return "down"
+	default:
+		return "flat"
+	}
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// CompletedPerDay counts the todos completed in [from, to) per calendar day
+// in tz. Reports, like the list, leave soft-deleted todos out.
+func (s *MongoTodoStore) CompletedPerDay(ctx context.Context, from, to time.Time, tz string) ([]dailyCount, error) {
+	pipeline := bson.A{
+		bson.M{"$match": notDeletedCondition()},
+		bson.M{"$match": bson.M{"completedAt": bson.M{"$gte": from, "$lt": to}}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format":   "%Y-%m-%d",
+				"date":     "$completedAt",
+				"timezone": tz,
+			}},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, scopePipeline(ctx, pipeline))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []dailyCount
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (app *App) getVelocity(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 365 {
+			app.respondError(w, http.StatusBadRequest, "days must be an integer between 1 and 365")
+			return
+		}
+		days = n
+	}
+
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		tz = "UTC"
+	}
+	// LoadLocation also takes "Local", the server's zone, which MongoDB
+	// does not know by that name
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		app.respondError(w, http.StatusBadRequest, "Invalid tz, expected an IANA time zone such as Europe/Berlin")
+		return
+	}
+
+	// Windows are whole days in the requested zone and include today
+	now := time.Now().In(loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -days)
+	previousStart := start.AddDate(0, 0, -days)
+
+	counts, err := app.store.CompletedPerDay(r.Context(), previousStart, end, tz)
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compute velocity")
+		return
+	}
+
+	byDate := make(map[string]int, len(counts))
+	for _, c := range counts {
+		byDate[c.Date] = c.Count
+	}
+
+	// Fill every day of the current window so sparse data still yields a full series
+	daily := make([]dailyCount, 0, days)
+	completed := 0
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		daily = append(daily, dailyCount{Date: date, Count: byDate[date]})
+		completed += byDate[date]
+	}
+
+	previous := 0
+	for day := previousStart; day.Before(start); day = day.AddDate(0, 0, 1) {
+		previous += byDate[day.Format("2006-01-02")]
+	}
+
+	average := float64(completed) / float64(days)
+	previousAverage := float64(previous) / float64(days)
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"days":                  days,
+		"timezone":              tz,
+		"completed":             completed,
+		"averagePerDay":         round2(average),
+		"previousAveragePerDay": round2(previousAverage),
+		"trend":                 trend(average, previousAverage),
+		"daily":                 daily,
+	})
+}
+
+// percentile returns the nearest-rank percentile p (0 to 100) of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// median returns the middle of sorted values, averaging the two middle ones for even lengths
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func (s *MongoTodoStore) CycleTimes(ctx context.Context) ([]float64, error) {
+	// Durations come back sorted so percentiles need no extra work
+	pipeline := bson.A{
+		bson.M{"$match": notDeletedCondition()},
+		bson.M{"$match": bson.M{"completed": true, "completedAt": bson.M{"$ne": nil}}},
+		bson.M{"$project": bson.M{
+			"_id":        0,
+			"durationMs": bson.M{"$subtract": bson.A{"$completedAt", "$createdAt"}},
+		}},
+		bson.M{"$sort": bson.M{"durationMs": 1}},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, scopePipeline(ctx, pipeline))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var seconds []float64
+	for cursor.Next(ctx) {
+		var row struct {
+			DurationMs int64 `bson:"durationMs"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		seconds = append(seconds, float64(row.DurationMs)/1000)
+	}
+	return seconds, cursor.Err()
+}
+
+func (app *App) getCycleTime(w http.ResponseWriter, r *http.Request) {
+	seconds, err := app.store.CycleTimes(r.Context())
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compute cycle time")
+		return
+	}
+
+	total := 0.0
+	for _, s := range seconds {
+		total += s
+	}
+
+	average := 0.0
+	if len(seconds) > 0 {
+		average = total / float64(len(seconds))
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"count":          len(seconds),
+		"averageSeconds": round2(average),
+		"medianSeconds":  round2(median(seconds)),
+		"p90Seconds":     round2(percentile(seconds, 90)),
+	})
+}
+
+// duplicateGroup is a set of todos whose titles match after normalization
+type duplicateGroup struct {
+	Title string               `json:"title" bson:"_id"`
+	Count int                  `json:"count" bson:"count"`
+	IDs   []primitive.ObjectID `json:"ids" bson:"ids"`
+}
+
+func (s *MongoTodoStore) Duplicates(ctx context.Context) ([]duplicateGroup, error) {
+	// Titles are normalized by trimming surrounding whitespace and lowercasing
+	pipeline := bson.A{
+		bson.M{"$match": notDeletedCondition()},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$title"}}},
+			"count": bson.M{"$sum": 1},
+			"ids":   bson.M{"$push": "$_id"},
+		}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, scopePipeline(ctx, pipeline))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []duplicateGroup{}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (app *App) getDuplicates(w http.ResponseWriter, r *http.Request) {
+	groups, err := app.store.Duplicates(r.Context())
+	if err != nil {
+		app.storeFailed(w, err, "Failed to find duplicates")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, groups)
+}
+
+// todoStats summarizes the todo list for the dashboard
+type todoStats struct {
+	Total     int64 `json:"total" bson:"total"`
+	Completed int64 `json:"completed" bson:"completed"`
+	Active    int64 `json:"active" bson:"-"`
+	Overdue   int64 `json:"overdue" bson:"overdue"`
+}
+
+// Stats counts the todos that are not soft deleted in a single $group. An
+// overdue todo is incomplete with a dueDate before now, as for ?overdue=true.
+func (s *MongoTodoStore) Stats(ctx context.Context, now time.Time) (todoStats, error) {
+	countIf := func(cond interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": notDeletedCondition()},
+		bson.M{"$group": bson.M{
+			"_id":       nil,
+			"total":     bson.M{"$sum": 1},
+			"completed": countIf("$completed"),
+			// Missing dates compare below every date in aggregation, so
+			// check the type before comparing
+			"overdue": countIf(bson.M{"$and": bson.A{
+				bson.M{"$ne": bson.A{"$completed", true}},
+				bson.M{"$eq": bson.A{bson.M{"$type": "$dueDate"}, "date"}},
+				bson.M{"$lt": bson.A{"$dueDate", now}},
+			}}),
+		}},
+	}
+
+	cursor, err := s.todos.Aggregate(ctx, scopePipeline(ctx, pipeline))
+	if err != nil {
+		return todoStats{}, err
+	}
+	defer cursor.Close(ctx)
+
+	// An empty collection yields no group at all
+	var stats todoStats
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return todoStats{}, err
+		}
+	}
+	stats.Active = stats.Total - stats.Completed
+	return stats, cursor.Err()
+}
+
+func (app *App) getStats(w http.ResponseWriter, r *http.Request) {
+	// Dashboards poll this, so give up quickly rather than pile up requests
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+
+	stats, err := app.store.Stats(ctx, time.Now())
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compute stats")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetVelocityInvalidParams(t *testing.T) {
+	h := newTestServer(newFakeStore())
+
+	tests := []string{
+		"days=0",
+		"days=366",
+		"days=week",
+		"tz=Mars/Olympus",
+		"tz=Local",
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			decodeResponse(t, send(h, http.MethodGet, "/api/v1/reports/velocity?"+query, ""), http.StatusBadRequest, nil)
+		})
+	}
+}
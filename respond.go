@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// envelope is the body of every JSON API response. Data is null on errors,
+// Error is null on success; Meta and Details only appear when set.
+type envelope struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data"`
+	Meta    interface{} `json:"meta,omitempty"`
+	Error   *string     `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// respondJSON writes data wrapped in a successful envelope
+func (app *App) respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	app.writeJSON(w, status, envelope{Success: true, Data: data})
+}
+
+// respondPage is respondJSON for list responses that carry metadata such as pagination
+func (app *App) respondPage(w http.ResponseWriter, status int, data, meta interface{}) {
+	app.writeJSON(w, status, envelope{Success: true, Data: data, Meta: meta})
+}
+
+// respondError writes msg wrapped in a failed envelope
+func (app *App) respondError(w http.ResponseWriter, status int, msg string) {
+	app.respondErrorDetails(w, status, msg, nil)
+}
+
+// respondErrorDetails is respondError with machine readable details, e.g.
+// the failing fields of a validation error
+func (app *App) respondErrorDetails(w http.ResponseWriter, status int, msg string, details interface{}) {
+	app.writeJSON(w, status, envelope{Success: false, Error: &msg, Details: details})
+}
+
+// storeFailed responds to an unexpected store error with 500 and msg, or
+// with 504 when the database did not answer before the context deadline
+func (app *App) storeFailed(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		slog.Warn("Database timed out", "error", err.Error())
+		app.respondError(w, http.StatusGatewayTimeout, "The database did not respond in time")
+		return
+	}
+
+	slog.Error(msg, "error", err.Error())
+	app.respondError(w, http.StatusInternalServerError, msg)
+}
+
+// writeJSON renders body through render
+func (app *App) writeJSON(w http.ResponseWriter, status int, body envelope) {
+	render(w, status, func(w http.ResponseWriter) error {
+		return app.renderer.JSON(w, status, body)
+	})
+}
+
+// bufferedResponse holds a rendered response until it is known to be complete
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// render runs fn against a buffer and only then sends the result, since the
+// renderer writes the status before it knows whether encoding succeeds. A
+// failed render is logged and answered with a plain 500; a failed write,
+// usually a client that went away, is logged.
+func render(w http.ResponseWriter, status int, fn func(http.ResponseWriter) error) {
+	buf := &bufferedResponse{header: http.Header{}, status: status}
+	if err := fn(buf); err != nil {
+		slog.Error("Failed to render response", "status", status, "error", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for key, values := range buf.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(buf.status)
+	if _, err := w.Write(buf.body.Bytes()); err != nil {
+		slog.Warn("Failed to write response", "status", buf.status, "error", err.Error())
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// routes builds the HTTP handler for app from cfg. Keeping it apart from
+// main lets the full router run against any TodoStore, e.g. under httptest.
+func (app *App) routes(cfg Config, logger *slog.Logger) http.Handler {
+	// Create router
+	router := chi.NewRouter()
+
+	// Middleware
+	router.Use(middleware.RequestID)
+	router.Use(middleware.RealIP)
+	router.Use(skipPaths(instrument, "/metrics"))
+	router.Use(skipPaths(requestLogger(logger, cfg.LogSampleRate), "/healthz"))
+	router.Use(middleware.Recoverer)
+
+	// Handler timeouts are applied per route group so slow reports can run
+	// longer than CRUD requests
+	requestTimeout := cfg.RequestTimeout
+	reportTimeout := cfg.ReportTimeout
+
+	// The server's WriteTimeout must outlast the handler timeouts for their
+	// 504 to reach the client, routes with longer ones extend it
+	writeTimeout := cfg.WriteTimeout
+
+	router.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(requestTimeout))
+
+		// Static files
+		workDir, _ := os.Getwd()
+		filesDir := http.Dir(filepath.Join(workDir, "static"))
+		r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(filesDir)))
+
+		// Routes
+		r.Get("/", app.homeHandler)
+		r.Get("/healthz", app.healthHandler)
+		r.Handle("/metrics", promhttp.Handler())
+		r.Get("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, filepath.Join(workDir, "static/favicon.ico"))
+		})
+
+		// The spec is public so tools can load it without credentials; chi
+		// prefers this static route over the /api/v1 subrouter below
+		r.With(cors(cfg.AllowedOrigins)).Get("/api/v1/openapi.json", app.getOpenAPI)
+
+		// Admin routes, only available when an admin token is configured
+		if token := cfg.AdminToken; token != "" {
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(app.requireAdminToken(token))
+				r.Use(app.requireJSON)
+				r.Get("/maintenance", app.getMaintenance)
+				r.Put("/maintenance", app.setMaintenance)
+			})
+		}
+	})
+
+	// API routes
+	router.Route("/api/v1", func(r chi.Router) {
+		// CORS comes first so preflights, which carry no credentials, are answered
+		r.Use(cors(cfg.AllowedOrigins))
+		if perMinute := cfg.RateLimitPerMinute; perMinute > 0 {
+			r.Use(app.limitRate(newRateLimiter(perMinute)))
+		}
+		if cfg.APIKey != "" {
+			r.Use(app.requireAPIKey(cfg.APIKey))
+		}
+		r.Use(app.requireOwner)
+		r.Use(app.maintenanceGate)
+		r.Use(app.requireJSON)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Timeout(requestTimeout))
+			r.Use(dbDeadline(app.dbTimeout))
+
+			r.Get("/todos", app.getTodos)
+			r.Get("/todos/stats", app.getStats)
+			r.Post("/todos", app.createTodo)
+			r.Post("/todos/bulk", app.bulkCreate)
+			r.Post("/todos/import", app.importTodos)
+			r.Post("/todos/validate", app.validateTodo)
+			r.Get("/todos/{id}", app.getTodo)
+			r.Get("/todos/{id}/position", app.getTodoPosition)
+			r.Put("/todos/{id}", app.updateTodo)
+			r.Patch("/todos/{id}", app.patchTodo)
+			r.Patch("/todos/{id}/complete", app.toggleComplete)
+			r.Post("/todos/{id}/restore", app.restoreTodo)
+			r.Post("/todos/undo", app.undoDelete)
+			r.Post("/todos/{id}/subtasks", app.addSubtask)
+			r.Patch("/todos/{id}/subtasks/{index}", app.toggleSubtask)
+			// The collection root only matches "/todos" exactly, so it never
+			// competes with /todos/{id}
+			r.Delete("/todos", app.deleteAllTodos)
+			// Registered before /todos/{id} so "completed" is never read as an ID
+			r.Delete("/todos/completed", app.deleteCompleted)
+			r.Delete("/todos/{id}", app.deleteTodo)
+		})
+
+		// Reports and snapshots scan the whole collection, their handlers
+		// use the request context so this timeout bounds the queries
+		r.Group(func(r chi.Router) {
+			r.Use(writeDeadline(reportTimeout + writeTimeout - requestTimeout))
+			r.Use(middleware.Timeout(reportTimeout))
+
+			r.Get("/todos/duplicates", app.getDuplicates)
+			r.Get("/todos/export.csv", app.exportCSV)
+			r.Get("/reports/velocity", app.getVelocity)
+			r.Get("/reports/cycle-time", app.getCycleTime)
+
+			r.Post("/snapshots", app.createSnapshot)
+			r.Get("/snapshots/{name}/diff", app.diffSnapshot)
+		})
+
+		// Event streams stay open indefinitely, so no handler timeout
+		r.With(writeDeadline(0)).Get("/todos/stream", app.streamTodos)
+	})
+
+	return router
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Snapshot represents a named copy of the todo list at a point in time.
+// Its todos are stored apart from it as snapshotItems.
+type Snapshot struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	OwnerID   string             `json:"-" bson:"ownerId"`
+	Count     int64              `json:"count" bson:"count"` // todos in the snapshot
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// snapshotItem is one todo of a snapshot. Items are documents of their own
+// so a snapshot is not bound by MongoDB's 16 MB document limit.
+type snapshotItem struct {
+	SnapshotID primitive.ObjectID `bson:"snapshotId"`
+	Todo       Todo               `bson:"todo"`
+}
+
+// snapshotBatchSize is how many items CreateSnapshot inserts at a time
+const snapshotBatchSize = 1000
+
+// TodoChange represents a todo that differs between a snapshot and now
+type TodoChange struct {
+	ID     primitive.ObjectID `json:"id"`
+	Before Todo               `json:"before"`
+	After  Todo               `json:"after"`
+}
+
+// SnapshotDiff represents the difference between a snapshot and the current list
+type SnapshotDiff struct {
+	Added   []Todo       `json:"added"`
+	Removed []Todo       `json:"removed"`
+	Changed []TodoChange `json:"changed"`
+}
+
+func newSnapshotDiff() *SnapshotDiff {
+	return &SnapshotDiff{
+		Added:   []Todo{},
+		Removed: []Todo{},
+		Changed: []TodoChange{},
+	}
+}
+
+// add records one todo compared by TodoStore.CompareSnapshot: before is nil
+// for a todo added since the snapshot, after for one removed since. The
+// lists come out in ID order, so the output is stable between calls.
+func (d *SnapshotDiff) add(before, after *Todo) {
+	switch {
+	case before == nil:
+		d.Added = append(d.Added, *after)
+	case after == nil:
+		d.Removed = append(d.Removed, *before)
+	case todoChanged(*before, *after):
+		d.Changed = append(d.Changed, TodoChange{ID: before.ID, Before: *before, After: *after})
+	}
+}
+
+// todoChanged reports whether the fields a user edits differ between two
+// versions of a todo
+func todoChanged(old, now Todo) bool {
+	return old.Title != now.Title || old.Completed != now.Completed || old.Priority != now.Priority || !sameTime(old.DueDate, now.DueDate) || !slices.Equal(old.Tags, now.Tags) || !slices.Equal(old.Subtasks, now.Subtasks) || old.Recurrence != now.Recurrence
+}
+
+// sameTime reports whether two optional timestamps are both unset or equal
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (s *MongoTodoStore) SnapshotExists(ctx context.Context, name string) (bool, error) {
+	count, err := s.snapshots.CountDocuments(ctx, scope(ctx, bson.M{"name": name}))
+	return count > 0, err
+}
+
+// CreateSnapshot copies the todos that are not soft deleted as items in
+// batches, then saves the snapshot itself. Saving it last keeps a snapshot
+// from being diffed before all its items exist; the unique owner_name index
+// rejects a second snapshot of the same name, whose items are dropped again.
+func (s *MongoTodoStore) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	if ownerID, ok := ownerFromContext(ctx); ok {
+		snapshot.OwnerID = ownerID
+	}
+	snapshot.Count = 0
+
+	batch := make([]interface{}, 0, snapshotBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := s.snapshotItems.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		snapshot.Count += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	err := s.Each(ctx, notDeletedCondition(), todoSort{field: "createdAt"}, func(todo Todo) error {
+		batch = append(batch, snapshotItem{SnapshotID: snapshot.ID, Todo: todo})
+		if len(batch) < snapshotBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		_, err = s.snapshots.InsertOne(ctx, snapshot)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// The snapshot was not saved, so its items would never be read. Clean up
+	// even when ctx is what failed.
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if _, cleanupErr := s.snapshotItems.DeleteMany(cleanupCtx, bson.M{"snapshotId": snapshot.ID}); cleanupErr != nil {
+		slog.Error("Failed to remove items of unsaved snapshot",
+			"snapshot_id", snapshot.ID.Hex(),
+			"error", cleanupErr.Error(),
+		)
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		return errDuplicateName
+	}
+	return err
+}
+
+func (s *MongoTodoStore) GetSnapshot(ctx context.Context, name string) (Snapshot, error) {
+	var snapshot Snapshot
+	err := s.snapshots.FindOne(ctx, scope(ctx, bson.M{"name": name})).Decode(&snapshot)
+	return snapshot, storeError(err)
+}
+
+// CompareSnapshot reads the items of snapshot and the current todos that
+// are not soft deleted side by side in ID order, so neither is held in memory
+func (s *MongoTodoStore) CompareSnapshot(ctx context.Context, snapshot Snapshot, fn func(before, after *Todo) error) error {
+	items, err := s.snapshotItems.Find(ctx, bson.M{"snapshotId": snapshot.ID},
+		options.Find().SetSort(bson.D{{Key: "todo._id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer items.Close(ctx)
+
+	current, err := s.todos.Find(ctx, scope(ctx, notDeletedCondition()),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer current.Close(ctx)
+
+	nextBefore := func() (*Todo, error) {
+		if !items.Next(ctx) {
+			return nil, items.Err()
+		}
+		var item snapshotItem
+		err := items.Decode(&item)
+		return &item.Todo, err
+	}
+	nextAfter := func() (*Todo, error) {
+		if !current.Next(ctx) {
+			return nil, current.Err()
+		}
+		var todo Todo
+		err := current.Decode(&todo)
+		return &todo, err
+	}
+
+	before, err := nextBefore()
+	if err != nil {
+		return err
+	}
+	after, err := nextAfter()
+	if err != nil {
+		return err
+	}
+
+	for before != nil || after != nil {
+		// ObjectIDs sort by their bytes, as in the queries above
+		order := 0
+		switch {
+		case before == nil:
+			order = 1
+		case after == nil:
+			order = -1
+		default:
+			order = bytes.Compare(before.ID[:], after.ID[:])
+		}
+
+		switch {
+		case order < 0:
+			err = fn(before, nil)
+		case order > 0:
+			err = fn(nil, after)
+		default:
+			err = fn(before, after)
+		}
+		if err != nil {
+			return err
+		}
+
+		if order <= 0 {
+			if before, err = nextBefore(); err != nil {
+				return err
+			}
+		}
+		if order >= 0 {
+			if after, err = nextAfter(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	snapshot := Snapshot{Name: body.Name}
+	if snapshot.Name == "" {
+		app.validationFailed(w, ValidationErrors{"name": "Name is required"})
+		return
+	}
+
+	ctx := r.Context()
+
+	// Spares copying the list for a name that is taken, the unique index
+	// still decides when two requests race
+	exists, err := app.store.SnapshotExists(ctx, snapshot.Name)
+	if err != nil {
+		app.storeFailed(w, err, "Failed to create snapshot")
+		return
+	}
+	if exists {
+		app.respondError(w, http.StatusConflict, "A snapshot with that name already exists")
+		return
+	}
+
+	snapshot.ID = primitive.NewObjectID()
+	snapshot.CreatedAt = time.Now()
+
+	err = app.store.CreateSnapshot(ctx, &snapshot)
+	if err == errDuplicateName {
+		app.respondError(w, http.StatusConflict, "A snapshot with that name already exists")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to create snapshot")
+		return
+	}
+
+	app.respondJSON(w, http.StatusCreated, snapshot)
+}
+
+func (app *App) diffSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	ctx := r.Context()
+
+	snapshot, err := app.store.GetSnapshot(ctx, name)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Snapshot not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to fetch snapshot")
+		return
+	}
+
+	diff := newSnapshotDiff()
+	err = app.store.CompareSnapshot(ctx, snapshot, func(before, after *Todo) error {
+		diff.add(before, after)
+		return nil
+	})
+	if err != nil {
+		app.storeFailed(w, err, "Failed to compare snapshot")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"snapshot":  snapshot.Name,
+		"createdAt": jsonTime(snapshot.CreatedAt),
+		"diff":      diff,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultSort is the ordering used when a request does not pass ?sort=
+const defaultSort = "-createdAt"
+
+// sortableFields maps the names clients may order by to the stored field.
+// Priority sorts on its numeric rank so high comes before medium and low.
+var sortableFields = map[string]string{
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+	"title":     "title",
+	"priority":  "priorityRank",
+}
+
+// todoSort is a parsed ?sort= value holding the stored field name. Ties are
+// always broken by _id in the same direction so the ordering is total and stable.
+type todoSort struct {
+	field string
+	desc  bool
+}
+
+// parseSort parses values like "title" or "-createdAt", where a leading "-"
+// means descending
+func parseSort(value string) (todoSort, error) {
+	if value == "" {
+		value = defaultSort
+	}
+
+	name := strings.TrimPrefix(value, "-")
+	field, ok := sortableFields[name]
+	if !ok {
+		return todoSort{}, fmt.Errorf("invalid sort field %q", name)
+	}
+	return todoSort{field: field, desc: name != value}, nil
+}
+
+func (s todoSort) direction() int {
+	if s.desc {
+		return -1
+	}
+	return 1
+}
+
+// document returns the sort for options.Find().SetSort
+func (s todoSort) document() bson.D {
+	return bson.D{{Key: s.field, Value: s.direction()}, {Key: "_id", Value: s.direction()}}
+}
+
+// before returns a filter matching every document that sorts ahead of a
+// document with the given sort value and ID
+func (s todoSort) before(value interface{}, id interface{}) bson.M {
+	op := "$lt"
+	if s.desc {
+		op = "$gt"
+	}
+	return s.compare(op, value, id)
+}
+
+// after returns a filter matching every document that sorts behind a
+// document with the given sort value and ID, which is the keyset page after it
+func (s todoSort) after(value interface{}, id interface{}) bson.M {
+	op := "$gt"
+	if s.desc {
+		op = "$lt"
+	}
+	return s.compare(op, value, id)
+}
+
+func (s todoSort) compare(op string, value interface{}, id interface{}) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{s.field: bson.M{op: value}},
+		bson.M{s.field: value, "_id": bson.M{op: id}},
+	}}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Errors returned by a TodoStore in place of driver errors
+var (
+	errNotFound       = errors.New("not found")
+	errDuplicateTitle = errors.New("duplicate title")
+	errDuplicateID    = errors.New("duplicate id")
+	errStaleVersion   = errors.New("stale version")
+	errDuplicateName  = errors.New("duplicate snapshot name")
+)
+
+// TodoStore is the persistence layer behind the handlers. Filters and
+// updates are Mongo query documents as built by filterBuilder and addUpdate;
+// results are domain types and the errors above. Every method only sees and
+// writes the todos of the owner in ctx, see withOwner.
+type TodoStore interface {
+	Ping(ctx context.Context) error
+
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	// List returns one page of the todos matching filter. For a keyset page it
+	// returns errNotFound when the page.after todo does not exist.
+	List(ctx context.Context, filter bson.M, sort todoSort, page pagination) ([]Todo, error)
+	// Each calls fn for every todo matching filter as they are read, stopping at the first error
+	Each(ctx context.Context, filter bson.M, sort todoSort, fn func(Todo) error) error
+	Facets(ctx context.Context, filter bson.M, fields []string) (map[string][]facetCount, error)
+	// Position returns the 1-based position of a todo in the list ordered by sort
+	Position(ctx context.Context, id primitive.ObjectID, sort todoSort) (int64, error)
+	Get(ctx context.Context, id primitive.ObjectID) (Todo, error)
+	// FirstWithTitle returns an open todo having any of titles, other than the todos in except
+	FirstWithTitle(ctx context.Context, titles []string, except []primitive.ObjectID) (Todo, error)
+
+	Create(ctx context.Context, todo Todo) error
+	CreateMany(ctx context.Context, todos []Todo) error
+	// Upsert replaces todos by ID in one ordered bulk write, inserting the missing ones
+	Upsert(ctx context.Context, todos []Todo) (inserted, updated int64, err error)
+	// Update applies update to a todo, increments its version and returns it
+	// as stored afterwards. A non-zero version makes the update conditional:
+	// errStaleVersion is returned when the stored version differs.
+	Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (Todo, error)
+	// Delete removes a todo and returns it as it was stored
+	Delete(ctx context.Context, id primitive.ObjectID) (Todo, error)
+	DeleteMany(ctx context.Context, filter bson.M) (int64, error)
+
+	CompletedPerDay(ctx context.Context, from, to time.Time, tz string) ([]dailyCount, error)
+	// CycleTimes returns the seconds from creation to completion of every completed todo, ascending
+	CycleTimes(ctx context.Context) ([]float64, error)
+	Duplicates(ctx context.Context) ([]duplicateGroup, error)
+	Stats(ctx context.Context, now time.Time) (todoStats, error)
+
+	// Watch streams todo changes until ctx is done, or returns errWatchUnsupported
+	Watch(ctx context.Context) (<-chan todoEvent, error)
+
+	SnapshotExists(ctx context.Context, name string) (bool, error)
+	// CreateSnapshot saves the todos that are not soft deleted under
+	// snapshot, setting its owner and Count. It returns errDuplicateName
+	// when the owner already has a snapshot of that name.
+	CreateSnapshot(ctx context.Context, snapshot *Snapshot) error
+	GetSnapshot(ctx context.Context, name string) (Snapshot, error)
+	// CompareSnapshot calls fn for every todo in snapshot or the current
+	// list, in ID order, with its version on each side: before is nil for
+	// todos added since, after for todos removed or soft deleted since
+	CompareSnapshot(ctx context.Context, snapshot Snapshot, fn func(before, after *Todo) error) error
+}
+
+// MongoTodoStore is the TodoStore backed by a MongoDB database
+type MongoTodoStore struct {
+	client        *mongo.Client
+	todos         *mongo.Collection
+	snapshots     *mongo.Collection
+	snapshotItems *mongo.Collection
+}
+
+var _ TodoStore = (*MongoTodoStore)(nil)
+
+func newMongoTodoStore(todos *mongo.Collection) *MongoTodoStore {
+	return &MongoTodoStore{
+		client:        todos.Database().Client(),
+		todos:         todos,
+		snapshots:     companionCollection(todos, "snapshots"),
+		snapshotItems: companionCollection(todos, "snapshot_items"),
+	}
+}
+
+// defaultCollection is the todos collection used when COLLECTION_NAME is unset
+const defaultCollection = "todos"
+
+// companionCollection returns the collection holding name data for todos,
+// prefixed with its collection name so several todo collections can share
+// a database: "snapshots" for todos, "work_snapshots" for work. The default
+// collection keeps the unprefixed names used before COLLECTION_NAME existed.
+func companionCollection(todos *mongo.Collection, name string) *mongo.Collection {
+	if todos.Name() != defaultCollection {
+		name = todos.Name() + "_" + name
+	}
+	return todos.Database().Collection(name)
+}
+
+// storeError maps driver errors onto the TodoStore errors. Besides _id the
+// title index is the only unique one, so any other duplicate key is a
+// duplicate title.
+func storeError(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return errNotFound
+	case duplicateID(err):
+		return errDuplicateID
+	case mongo.IsDuplicateKeyError(err):
+		return errDuplicateTitle
+	default:
+		return err
+	}
+}
+
+// duplicateID reports whether err is a duplicate key error on _id, going by
+// the keyPattern the server reports with the write error, or by the index
+// named in the message for servers older than 4.4
+func duplicateID(err error) bool {
+	var writeErrors []mongo.WriteError
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		writeErrors = append(writeErrors, writeErr.WriteErrors...)
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, we := range bulkErr.WriteErrors {
+			writeErrors = append(writeErrors, we.WriteError)
+		}
+	}
+
+	for _, we := range writeErrors {
+		if we.Code != 11000 {
+			continue
+		}
+		if _, lookupErr := we.Raw.LookupErr("keyPattern", "_id"); lookupErr == nil {
+			return true
+		}
+		if strings.Contains(we.Message, " index: _id_ ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MongoTodoStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+func (s *MongoTodoStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return s.todos.CountDocuments(ctx, scope(ctx, filter))
+}
+
+func (s *MongoTodoStore) List(ctx context.Context, filter bson.M, sort todoSort, page pagination) ([]Todo, error) {
+	opts := options.Find().
+		SetSkip(page.skip()).
+		SetLimit(int64(page.limit)).
+		SetSort(sort.document())
+
+	if page.keyset() {
+		var doc bson.M
+		if err := s.todos.FindOne(ctx, scope(ctx, bson.M{"_id": page.after})).Decode(&doc); err != nil {
+			return nil, storeError(err)
+		}
+		filter = bson.M{"$and": bson.A{filter, sort.after(doc[sort.field], page.after)}}
+	}
+
+	return s.find(ctx, filter, opts)
+}
+
+func (s *MongoTodoStore) Each(ctx context.Context, filter bson.M, sort todoSort, fn func(Todo) error) error {
+	cursor, err := s.todos.Find(ctx, scope(ctx, filter), options.Find().SetSort(sort.document()))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var todo Todo
+		if err := cursor.Decode(&todo); err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *MongoTodoStore) find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]Todo, error) {
+	cursor, err := s.todos.Find(ctx, scope(ctx, filter), opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	todos := []Todo{}
+	if err = cursor.All(ctx, &todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+func (s *MongoTodoStore) Position(ctx context.Context, id primitive.ObjectID, sort todoSort) (int64, error) {
+	// Read the raw document so the sort value can be looked up by stored field name
+	var doc bson.M
+	if err := s.todos.FindOne(ctx, scope(ctx, bson.M{"_id": id})).Decode(&doc); err != nil {
+		return 0, storeError(err)
+	}
+
+	// Soft-deleted todos are not listed, so they do not take up positions
+	before := bson.M{"$and": bson.A{sort.before(doc[sort.field], id), notDeletedCondition()}}
+	ahead, err := s.todos.CountDocuments(ctx, scope(ctx, before))
+	if err != nil {
+		return 0, err
+	}
+	return ahead + 1, nil
+}
+
+func (s *MongoTodoStore) Get(ctx context.Context, id primitive.ObjectID) (Todo, error) {
+	var todo Todo
+	err := s.todos.FindOne(ctx, scope(ctx, bson.M{"_id": id})).Decode(&todo)
+	return todo, storeError(err)
+}
+
+func (s *MongoTodoStore) FirstWithTitle(ctx context.Context, titles []string, except []primitive.ObjectID) (Todo, error) {
+	filter := bson.M{"title": bson.M{"$in": titles}, "completed": false}
+	if len(except) > 0 {
+		filter["_id"] = bson.M{"$nin": except}
+	}
+
+	var todo Todo
+	err := s.todos.FindOne(ctx, scope(ctx, filter)).Decode(&todo)
+	return todo, storeError(err)
+}
+
+// own sets the owner of todo to the one in ctx, if any
+func own(ctx context.Context, todo *Todo) {
+	if ownerID, ok := ownerFromContext(ctx); ok {
+		todo.OwnerID = ownerID
+	}
+}
+
+func (s *MongoTodoStore) Create(ctx context.Context, todo Todo) error {
+	own(ctx, &todo)
+	_, err := s.todos.InsertOne(ctx, todo)
+	return storeError(err)
+}
+
+func (s *MongoTodoStore) CreateMany(ctx context.Context, todos []Todo) error {
+	docs := make([]interface{}, len(todos))
+	for i := range todos {
+		own(ctx, &todos[i])
+		docs[i] = todos[i]
+	}
+
+	_, err := s.todos.InsertMany(ctx, docs)
+	return storeError(err)
+}
+
+func (s *MongoTodoStore) Upsert(ctx context.Context, todos []Todo) (int64, int64, error) {
+	models := make([]mongo.WriteModel, len(todos))
+	for i, todo := range todos {
+		// Another owner's todo is not matched, so upserting its ID fails on
+		// the _id index instead of taking it over
+		own(ctx, &todo)
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(scope(ctx, bson.M{"_id": todo.ID})).
+			SetReplacement(todo).
+			SetUpsert(true)
+	}
+
+	result, err := s.todos.BulkWrite(ctx, models)
+	if err != nil {
+		return 0, 0, storeError(err)
+	}
+	return result.UpsertedCount, result.MatchedCount, nil
+}
+
+func (s *MongoTodoStore) Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (Todo, error) {
+	filter := bson.M{"_id": id}
+	if version != 0 {
+		filter["version"] = version
+	}
+	filter = scope(ctx, filter)
+	addUpdate(update, "$inc", "version", 1)
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated Todo
+	err := s.todos.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments && version != 0 {
+		// Tell a missing todo apart from one that changed since it was read
+		count, countErr := s.todos.CountDocuments(ctx, scope(ctx, bson.M{"_id": id}))
+		if countErr != nil {
+			return Todo{}, countErr
+		}
+		if count > 0 {
+			return Todo{}, errStaleVersion
+		}
+	}
+	return updated, storeError(err)
+}
+
+func (s *MongoTodoStore) Delete(ctx context.Context, id primitive.ObjectID) (Todo, error) {
+	var deleted Todo
+	err := s.todos.FindOneAndDelete(ctx, scope(ctx, bson.M{"_id": id})).Decode(&deleted)
+	return deleted, storeError(err)
+}
+
+func (s *MongoTodoStore) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	result, err := s.todos.DeleteMany(ctx, scope(ctx, filter))
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
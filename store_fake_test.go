@@ -0,0 +1,786 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errFakeUnsupported is returned by the fakeStore methods handler tests do not need
+var errFakeUnsupported = errors.New("not supported by fakeStore")
+
+// fakeStore is an in-memory TodoStore for handler tests. It evaluates the
+// subset of query and update documents the handlers build, scopes every
+// method to the owner in ctx like MongoTodoStore, and keeps open titles
+// unique per owner like the owner_title_open_unique index.
+type fakeStore struct {
+	mu    sync.Mutex
+	todos map[primitive.ObjectID]Todo
+
+	// err, when set, is returned by every method instead of doing the work
+	err error
+	// onCall, when set, is called with the context of every method call
+	onCall func(ctx context.Context)
+}
+
+var _ TodoStore = (*fakeStore)(nil)
+
+func newFakeStore(todos ...Todo) *fakeStore {
+	s := &fakeStore{todos: map[primitive.ObjectID]Todo{}}
+	for _, todo := range todos {
+		s.todos[todo.ID] = todo
+	}
+	return s
+}
+
+// call runs the hooks and, like the driver, fails once ctx is done
+func (s *fakeStore) call(ctx context.Context) error {
+	if s.onCall != nil {
+		s.onCall(ctx)
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return ctx.Err()
+}
+
+// visible reports whether todo belongs to the owner in ctx, if any
+func visible(ctx context.Context, todo Todo) bool {
+	ownerID, ok := ownerFromContext(ctx)
+	return !ok || todo.OwnerID == ownerID
+}
+
+// matching returns the visible todos matching filter as documents, ordered by sort
+func (s *fakeStore) matching(ctx context.Context, filter bson.M, sort todoSort) ([]bson.M, error) {
+	var docs []bson.M
+	for _, todo := range s.todos {
+		if !visible(ctx, todo) {
+			continue
+		}
+		doc, err := toDoc(todo)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := matches(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	sortDocs(docs, sort)
+	return docs, nil
+}
+
+func (s *fakeStore) Ping(ctx context.Context) error {
+	return s.call(ctx)
+}
+
+func (s *fakeStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return 0, err
+	}
+
+	docs, err := s.matching(ctx, filter, todoSort{field: "_id"})
+	return int64(len(docs)), err
+}
+
+func (s *fakeStore) List(ctx context.Context, filter bson.M, sort todoSort, page pagination) ([]Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return nil, err
+	}
+
+	if page.keyset() {
+		after, ok := s.todos[page.after]
+		if !ok || !visible(ctx, after) {
+			return nil, errNotFound
+		}
+		doc, err := toDoc(after)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, sort.after(doc[sort.field], page.after)}}
+	}
+
+	docs, err := s.matching(ctx, filter, sort)
+	if err != nil {
+		return nil, err
+	}
+
+	todos := []Todo{}
+	for i := page.skip(); i < int64(len(docs)) && len(todos) < page.limit; i++ {
+		todo, err := fromDoc(docs[i])
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, nil
+}
+
+func (s *fakeStore) Each(ctx context.Context, filter bson.M, sort todoSort, fn func(Todo) error) error {
+	s.mu.Lock()
+	if err := s.call(ctx); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	docs, err := s.matching(ctx, filter, sort)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		todo, err := fromDoc(doc)
+		if err != nil {
+			return err
+		}
+		if err := fn(todo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) Facets(ctx context.Context, filter bson.M, fields []string) (map[string][]facetCount, error) {
+	return nil, errFakeUnsupported
+}
+
+func (s *fakeStore) Position(ctx context.Context, id primitive.ObjectID, sort todoSort) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return 0, err
+	}
+
+	todo, ok := s.todos[id]
+	if !ok || !visible(ctx, todo) {
+		return 0, errNotFound
+	}
+	doc, err := toDoc(todo)
+	if err != nil {
+		return 0, err
+	}
+
+	ahead, err := s.matching(ctx, bson.M{"$and": bson.A{sort.before(doc[sort.field], id), notDeletedCondition()}}, sort)
+	return int64(len(ahead)) + 1, err
+}
+
+func (s *fakeStore) Get(ctx context.Context, id primitive.ObjectID) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return Todo{}, err
+	}
+
+	todo, ok := s.todos[id]
+	if !ok || !visible(ctx, todo) {
+		return Todo{}, errNotFound
+	}
+	return todo, nil
+}
+
+func (s *fakeStore) FirstWithTitle(ctx context.Context, titles []string, except []primitive.ObjectID) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return Todo{}, err
+	}
+
+	filter := bson.M{"title": bson.M{"$in": titles}, "completed": false}
+	if len(except) > 0 {
+		filter["_id"] = bson.M{"$nin": except}
+	}
+	docs, err := s.matching(ctx, filter, todoSort{field: "_id"})
+	if err != nil {
+		return Todo{}, err
+	}
+	if len(docs) == 0 {
+		return Todo{}, errNotFound
+	}
+	return fromDoc(docs[0])
+}
+
+// titleTaken reports whether another open todo of the same owner has the title of todo
+func (s *fakeStore) titleTaken(todo Todo) bool {
+	if todo.Completed {
+		return false
+	}
+	for id, other := range s.todos {
+		if id != todo.ID && !other.Completed && other.OwnerID == todo.OwnerID && other.Title == todo.Title {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeStore) insert(ctx context.Context, todo Todo) error {
+	own(ctx, &todo)
+	if _, ok := s.todos[todo.ID]; ok {
+		return errDuplicateID
+	}
+	if s.titleTaken(todo) {
+		return errDuplicateTitle
+	}
+	s.todos[todo.ID] = todo
+	return nil
+}
+
+func (s *fakeStore) Create(ctx context.Context, todo Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+	return s.insert(ctx, todo)
+}
+
+// CreateMany stops at the first failing todo and keeps the earlier ones, as
+// an ordered InsertMany does
+func (s *fakeStore) CreateMany(ctx context.Context, todos []Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return err
+	}
+
+	for i := range todos {
+		own(ctx, &todos[i])
+		if err := s.insert(ctx, todos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) Upsert(ctx context.Context, todos []Todo) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	var inserted, updated int64
+	for _, todo := range todos {
+		own(ctx, &todo)
+		stored, ok := s.todos[todo.ID]
+		if !ok {
+			if err := s.insert(ctx, todo); err != nil {
+				return inserted, updated, err
+			}
+			inserted++
+			continue
+		}
+		if stored.OwnerID != todo.OwnerID {
+			return inserted, updated, errDuplicateID
+		}
+		if s.titleTaken(todo) {
+			return inserted, updated, errDuplicateTitle
+		}
+		s.todos[todo.ID] = todo
+		updated++
+	}
+	return inserted, updated, nil
+}
+
+func (s *fakeStore) Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return Todo{}, err
+	}
+
+	todo, ok := s.todos[id]
+	if !ok || !visible(ctx, todo) {
+		return Todo{}, errNotFound
+	}
+	if version != 0 && todo.Version != version {
+		return Todo{}, errStaleVersion
+	}
+
+	doc, err := toDoc(todo)
+	if err != nil {
+		return Todo{}, err
+	}
+	addUpdate(update, "$inc", "version", 1)
+	if err := applyUpdate(doc, update); err != nil {
+		return Todo{}, err
+	}
+	updated, err := fromDoc(doc)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	if s.titleTaken(updated) {
+		return Todo{}, errDuplicateTitle
+	}
+	s.todos[id] = updated
+	return updated, nil
+}
+
+func (s *fakeStore) Delete(ctx context.Context, id primitive.ObjectID) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return Todo{}, err
+	}
+
+	todo, ok := s.todos[id]
+	if !ok || !visible(ctx, todo) {
+		return Todo{}, errNotFound
+	}
+	delete(s.todos, id)
+	return todo, nil
+}
+
+func (s *fakeStore) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ctx); err != nil {
+		return 0, err
+	}
+
+	docs, err := s.matching(ctx, filter, todoSort{field: "_id"})
+	if err != nil {
+		return 0, err
+	}
+	for _, doc := range docs {
+		delete(s.todos, doc["_id"].(primitive.ObjectID))
+	}
+	return int64(len(docs)), nil
+}
+
+func (s *fakeStore) CompletedPerDay(ctx context.Context, from, to time.Time, tz string) ([]dailyCount, error) {
+	return nil, errFakeUnsupported
+}
+
+func (s *fakeStore) CycleTimes(ctx context.Context) ([]float64, error) {
+	return nil, errFakeUnsupported
+}
+
+func (s *fakeStore) Duplicates(ctx context.Context) ([]duplicateGroup, error) {
+	return nil, errFakeUnsupported
+}
+
+func (s *fakeStore) Stats(ctx context.Context, now time.Time) (todoStats, error) {
+	return todoStats{}, errFakeUnsupported
+}
+
+func (s *fakeStore) Watch(ctx context.Context) (<-chan todoEvent, error) {
+	return nil, errWatchUnsupported
+}
+
+func (s *fakeStore) SnapshotExists(ctx context.Context, name string) (bool, error) {
+	return false, errFakeUnsupported
+}
+
+func (s *fakeStore) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	return errFakeUnsupported
+}
+
+func (s *fakeStore) GetSnapshot(ctx context.Context, name string) (Snapshot, error) {
+	return Snapshot{}, errFakeUnsupported
+}
+
+func (s *fakeStore) CompareSnapshot(ctx context.Context, snapshot Snapshot, fn func(before, after *Todo) error) error {
+	return errFakeUnsupported
+}
+
+// toDoc converts todo to the document MongoDB would store
+func toDoc(todo Todo) (bson.M, error) {
+	data, err := bson.Marshal(todo)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func fromDoc(doc bson.M) (Todo, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return Todo{}, err
+	}
+	var todo Todo
+	err = bson.Unmarshal(data, &todo)
+	return todo, err
+}
+
+// normalize converts a Go value to the type it decodes as from BSON, e.g.
+// time.Time to primitive.DateTime, so it compares with document values
+func normalize(value interface{}) interface{} {
+	data, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		panic(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		panic(err)
+	}
+	return doc["v"]
+}
+
+// lookup returns the value at a dotted path such as "subtasks.0.done"
+func lookup(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case bson.M:
+			value, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case primitive.A:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// matches evaluates filter against doc. It supports $and, $or, $nor and
+// the field operators the handlers use, and fails on anything else so a
+// test never passes on a filter the fake silently ignored.
+func matches(doc bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		var ok bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, condition)
+		default:
+			value, exists := lookup(doc, key)
+			ok, err = matchField(value, exists, condition)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, condition interface{}) (bool, error) {
+	conditions, ok := condition.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("fakeStore: %s needs a bson.A, got %T", op, condition)
+	}
+
+	matched := 0
+	for _, c := range conditions {
+		sub, ok := c.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("fakeStore: %s entries must be bson.M, got %T", op, c)
+		}
+		ok, err := matches(doc, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	switch op {
+	case "$and":
+		return matched == len(conditions), nil
+	case "$or":
+		return matched > 0, nil
+	default:
+		return matched == 0, nil
+	}
+}
+
+func matchField(value interface{}, exists bool, condition interface{}) (bool, error) {
+	ops, ok := condition.(bson.M)
+	if !ok || !isOperatorDoc(ops) {
+		return equal(value, condition), nil
+	}
+
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$exists":
+			ok = exists == arg.(bool)
+		case "$ne":
+			ok = !equal(value, arg)
+		case "$lt", "$lte", "$gt", "$gte":
+			order, comparable := compare(value, normalize(arg))
+			ok = exists && comparable && map[string]bool{
+				"$lt":  order < 0,
+				"$lte": order <= 0,
+				"$gt":  order > 0,
+				"$gte": order >= 0,
+			}[op]
+		case "$in", "$nin", "$all":
+			values, isArray := normalize(arg).(primitive.A)
+			if !isArray {
+				return false, fmt.Errorf("fakeStore: %s needs an array, got %T", op, arg)
+			}
+			found := 0
+			for _, want := range values {
+				if equal(value, want) {
+					found++
+				}
+			}
+			switch op {
+			case "$in":
+				ok = found > 0
+			case "$nin":
+				ok = found == 0
+			default:
+				ok = found == len(values)
+			}
+		case "$regex":
+			pattern := arg.(string)
+			if options, _ := ops["$options"].(string); strings.Contains(options, "i") {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, err
+			}
+			ok = anyValue(value, func(v interface{}) bool {
+				s, isString := v.(string)
+				return isString && re.MatchString(s)
+			})
+		case "$options":
+			ok = true
+		default:
+			return false, fmt.Errorf("fakeStore: unsupported query operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isOperatorDoc(doc bson.M) bool {
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return len(doc) > 0
+}
+
+// anyValue reports whether fn holds for value or, for arrays, any of its elements
+func anyValue(value interface{}, fn func(interface{}) bool) bool {
+	if fn(value) {
+		return true
+	}
+	if values, ok := value.(primitive.A); ok {
+		for _, v := range values {
+			if fn(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equal matches value against want as a query equality does: arrays match
+// when any element does, and a missing value matches nil
+func equal(value, want interface{}) bool {
+	want = normalize(want)
+	return anyValue(value, func(v interface{}) bool {
+		if order, ok := compare(v, want); ok {
+			return order == 0
+		}
+		return reflect.DeepEqual(v, want)
+	})
+}
+
+// compare orders two document values of the same kind. Missing values sort
+// first, as null does in MongoDB.
+func compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0, true
+		case a == nil:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		if !ok || x == y {
+			return 0, ok
+		}
+		if !x {
+			return -1, true
+		}
+		return 1, true
+	case primitive.DateTime:
+		y, ok := b.(primitive.DateTime)
+		if !ok {
+			return 0, false
+		}
+		return compare(int64(x), int64(y))
+	case primitive.ObjectID:
+		y, ok := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:]), ok
+	}
+	return 0, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// sortDocs orders docs by sort, breaking ties by _id in the same direction
+func sortDocs(docs []bson.M, s todoSort) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		order, _ := compare(docs[i][s.field], docs[j][s.field])
+		if order == 0 {
+			order, _ = compare(docs[i]["_id"], docs[j]["_id"])
+		}
+		if s.desc {
+			return order > 0
+		}
+		return order < 0
+	})
+}
+
+// applyUpdate applies the update operators the handlers use to doc
+func applyUpdate(doc bson.M, update bson.M) error {
+	for op, value := range update {
+		fields, ok := value.(bson.M)
+		if !ok {
+			return fmt.Errorf("fakeStore: %s needs a bson.M, got %T", op, value)
+		}
+		for path, arg := range fields {
+			current, exists := lookup(doc, path)
+			switch op {
+			case "$set":
+				if err := setPath(doc, path, normalize(arg)); err != nil {
+					return err
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				x, _ := number(current)
+				y, ok := number(normalize(arg))
+				if !ok {
+					return fmt.Errorf("fakeStore: $inc needs a number, got %T", arg)
+				}
+				if err := setPath(doc, path, int64(x+y)); err != nil {
+					return err
+				}
+			case "$min":
+				arg = normalize(arg)
+				if order, _ := compare(arg, current); !exists || current == nil || order < 0 {
+					if err := setPath(doc, path, arg); err != nil {
+						return err
+					}
+				}
+			case "$push":
+				values, _ := current.(primitive.A)
+				if err := setPath(doc, path, append(values, normalize(arg))); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("fakeStore: unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+// setPath sets the value at a dotted path, creating missing documents on the way
+func setPath(doc bson.M, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	var current interface{} = doc
+	for i, key := range keys {
+		last := i == len(keys)-1
+		switch v := current.(type) {
+		case bson.M:
+			if last {
+				v[key] = value
+				return nil
+			}
+			if _, ok := v[key]; !ok {
+				v[key] = bson.M{}
+			}
+			current = v[key]
+		case primitive.A:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return fmt.Errorf("fakeStore: cannot set %s", path)
+			}
+			if last {
+				v[index] = value
+				return nil
+			}
+			current = v[index]
+		default:
+			return fmt.Errorf("fakeStore: cannot set %s", path)
+		}
+	}
+	return nil
+}
+
+func unsetPath(doc bson.M, path string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		delete(doc, path)
+		return
+	}
+	if parent, ok := lookup(doc, path[:i]); ok {
+		if m, ok := parent.(bson.M); ok {
+			delete(m, path[i+1:])
+		}
+	}
+}
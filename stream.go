@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errWatchUnsupported is returned by Watch when the deployment has no
+// change streams, which need a replica set or sharded cluster
+var errWatchUnsupported = errors.New("change streams unavailable")
+
+// sseHeartbeat is how often an idle stream sends a comment so proxies keep it open
+const sseHeartbeat = 30 * time.Second
+
+// todoEvent is one change pushed to stream clients. Todo is the document
+// after the change and is absent for deletes.
+type todoEvent struct {
+	Type string             `json:"type"`
+	ID   primitive.ObjectID `json:"id"`
+	Todo *Todo              `json:"todo,omitempty"`
+}
+
+// changeEventTypes maps change stream operation types to todoEvent types
+var changeEventTypes = map[string]string{
+	"insert":  "create",
+	"update":  "update",
+	"replace": "update",
+	"delete":  "delete",
+}
+
+// Watch streams todo changes until ctx is done. The channel is closed when
+// the change stream ends.
+func (s *MongoTodoStore) Watch(ctx context.Context) (<-chan todoEvent, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}},
+	}
+	// Delete events carry only the ID, so they pass this match for every
+	// owner and are filtered by ID below
+	ownerID, scoped := ownerFromContext(ctx)
+	if scoped {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"$or": bson.A{
+			bson.M{"fullDocument.ownerId": ownerID},
+			bson.M{"operationType": "delete"},
+		}}})
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+
+	stream, err := s.todos.Watch(ctx, pipeline, opts)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		// 40573: $changeStream is only supported on replica sets
+		if errors.As(err, &cmdErr) && cmdErr.Code == 40573 {
+			return nil, errWatchUnsupported
+		}
+		return nil, err
+	}
+
+	// owned holds the IDs of the owner's todos: those that exist once the
+	// stream is open, and those the stream reports afterwards. Only their
+	// deletes are forwarded, so other owners' IDs never reach the client.
+	var owned map[primitive.ObjectID]bool
+	if scoped {
+		owned, err = s.ownedIDs(ctx)
+		if err != nil {
+			stream.Close(context.Background())
+			return nil, err
+		}
+	}
+
+	events := make(chan todoEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.Background())
+
+		for stream.Next(ctx) {
+			var change struct {
+				OperationType string `bson:"operationType"`
+				DocumentKey   struct {
+					ID primitive.ObjectID `bson:"_id"`
+				} `bson:"documentKey"`
+				FullDocument *Todo `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				slog.Error("Failed to decode change event", "error", err.Error())
+				continue
+			}
+
+			event := todoEvent{
+				Type: changeEventTypes[change.OperationType],
+				ID:   change.DocumentKey.ID,
+				Todo: change.FullDocument,
+			}
+			if owned != nil {
+				if event.Type == "delete" {
+					if !owned[event.ID] {
+						continue
+					}
+					delete(owned, event.ID)
+				} else {
+					owned[event.ID] = true
+				}
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			slog.Error("Change stream ended", "error", err.Error())
+		}
+	}()
+	return events, nil
+}
+
+// ownedIDs returns the IDs of every todo of the owner in ctx
+func (s *MongoTodoStore) ownedIDs(ctx context.Context) (map[primitive.ObjectID]bool, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := s.todos.Find(ctx, scope(ctx, bson.M{}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := map[primitive.ObjectID]bool{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids[doc.ID] = true
+	}
+	return ids, cursor.Err()
+}
+
+func (app *App) streamTodos(w http.ResponseWriter, r *http.Request) {
+	// The watch lives as long as the request, a client disconnect cancels it
+	ctx := r.Context()
+
+	events, err := app.store.Watch(ctx)
+	if err == errWatchUnsupported {
+		app.respondError(w, http.StatusServiceUnavailable, "Live updates need MongoDB to run as a replica set")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to watch todos")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Subtask is a checklist item of a todo, addressed by its position
+type Subtask struct {
+	Title string `json:"title" bson:"title"`
+	Done  bool   `json:"done" bson:"done"`
+}
+
+// normalizeSubtasks trims subtask titles and returns why one is
+// unacceptable, or "" when all are valid. A nil list becomes empty.
+func normalizeSubtasks(subtasks []Subtask) ([]Subtask, string) {
+	if subtasks == nil {
+		return []Subtask{}, ""
+	}
+	for i := range subtasks {
+		subtasks[i].Title = strings.TrimSpace(subtasks[i].Title)
+		if msg := validateTitle(subtasks[i].Title); msg != "" {
+			return subtasks, fmt.Sprintf("Subtask %d: %s", i, msg)
+		}
+	}
+	return subtasks, ""
+}
+
+func (app *App) addSubtask(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	var subtask Subtask
+	if err := decodeJSON(w, r, &subtask); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	subtask.Title = strings.TrimSpace(subtask.Title)
+	if msg := validateTitle(subtask.Title); msg != "" {
+		app.validationFailed(w, ValidationErrors{"title": msg})
+		return
+	}
+
+	ctx := r.Context()
+
+	update := bson.M{
+		"$push": bson.M{"subtasks": subtask},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+
+	updated, err := app.store.Update(ctx, objID, 0, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to add subtask")
+		return
+	}
+
+	app.respondJSON(w, http.StatusCreated, updated)
+}
+
+func (app *App) toggleSubtask(w http.ResponseWriter, r *http.Request) {
+	objID, ok := app.idParam(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || index < 0 {
+		app.respondError(w, http.StatusBadRequest, "Invalid subtask index")
+		return
+	}
+
+	// An empty body flips the current state, {"done": bool} sets it
+	var body struct {
+		Done *bool `json:"done"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil && err != io.EOF {
+		app.invalidBody(w, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	existing, err := app.store.Get(ctx, objID)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to fetch todo")
+		return
+	}
+	if index >= len(existing.Subtasks) {
+		app.respondError(w, http.StatusNotFound, "Subtask not found")
+		return
+	}
+
+	done := !existing.Subtasks[index].Done
+	if body.Done != nil {
+		done = *body.Done
+	}
+
+	// Only the one element is written. The version check makes sure the
+	// index still points at the subtask that was read.
+	update := bson.M{"$set": bson.M{
+		fmt.Sprintf("subtasks.%d.done", index): done,
+		"updatedAt":                            time.Now(),
+	}}
+
+	updated, err := app.store.Update(ctx, objID, existing.Version, update)
+	if err == errNotFound {
+		app.respondError(w, http.StatusNotFound, "Todo not found")
+		return
+	}
+	if err == errStaleVersion {
+		app.respondError(w, http.StatusConflict, "The todo was modified by someone else, refetch it and retry")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to update subtask")
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, updated)
+}
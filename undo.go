@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// undoTTL is how long a hard-deleted todo can be brought back with its undo token
+const undoTTL = 30 * time.Second
+
+// undoStore keeps recently deleted todos in memory by undo token. Tokens
+// do not survive a restart and are not shared between instances.
+type undoStore struct {
+	mu      sync.Mutex
+	entries map[string]undoEntry
+}
+
+type undoEntry struct {
+	todo    Todo
+	expires time.Time
+}
+
+// newUndoStore starts a goroutine that drops expired entries every ttl
+func newUndoStore(ttl time.Duration) *undoStore {
+	s := &undoStore{entries: map[string]undoEntry{}}
+	go s.cleanup(ttl)
+	return s
+}
+
+// save keeps todo until expires and returns the token to restore it with
+func (s *undoStore) save(todo Todo, expires time.Time) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[token] = undoEntry{todo: todo, expires: expires}
+	return token
+}
+
+// get returns the todo saved under token unless it has expired by now
+func (s *undoStore) get(token string, now time.Time) (Todo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[token]
+	if !ok || !now.Before(entry.expires) {
+		return Todo{}, false
+	}
+	return entry.todo, true
+}
+
+func (s *undoStore) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, token)
+}
+
+func (s *undoStore) cleanup(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		s.mu.Lock()
+		for token, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, token)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// undoDelete re-inserts a hard-deleted todo, with its original ID and
+// fields, from the token deleteTodo returned. The token is only used up
+// once the todo is back, so a failed attempt can be retried until it expires.
+func (app *App) undoDelete(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+	if body.Token == "" {
+		app.validationFailed(w, ValidationErrors{"token": "Token is required"})
+		return
+	}
+
+	ctx := r.Context()
+
+	// Tokens of other owners are treated like unknown ones
+	todo, ok := app.undo.get(body.Token, time.Now())
+	if ownerID, _ := ownerFromContext(ctx); !ok || todo.OwnerID != ownerID {
+		app.respondError(w, http.StatusGone, "The undo token is unknown or has expired")
+		return
+	}
+
+	err := app.store.Create(ctx, todo)
+	if err == errDuplicateID {
+		app.respondError(w, http.StatusConflict, "The todo was already restored")
+		return
+	}
+	if err == errDuplicateTitle {
+		app.respondError(w, http.StatusConflict, "The title of the todo is taken by another open todo")
+		return
+	}
+	if err != nil {
+		app.storeFailed(w, err, "Failed to restore todo")
+		return
+	}
+	app.undo.remove(body.Token)
+
+	w.Header().Set("Location", todoLocation(todo.ID))
+	app.respondJSON(w, http.StatusCreated, todo)
+}
+
+// undoResponse is the deleteTodo response for a hard delete
+func undoResponse(token string, expires time.Time) renderer.M {
+	return renderer.M{
+		"message":       "Todo deleted successfully",
+		"undoToken":     token,
+		"undoExpiresAt": jsonTime(expires),
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// ValidationErrors maps a field name to the reason it failed validation
+type ValidationErrors map[string]string
+
+func (v ValidationErrors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, v[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// defaultPriority is assigned to todos created without a priority
+const defaultPriority = "medium"
+
+// priorityRanks maps each allowed priority to the rank stored alongside it
+// for sorting, most urgent first
+var priorityRanks = map[string]int{
+	"high":   1,
+	"medium": 2,
+	"low":    3,
+}
+
+// maxTitleLength is the longest title accepted, in characters
+const maxTitleLength = 200
+
+// validateTitle returns why title is unacceptable, or "" when it is valid.
+// Titles are trimmed before they are validated and stored.
+func validateTitle(title string) string {
+	if title == "" {
+		return "Title is required"
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Sprintf("Title must be at most %d characters", maxTitleLength)
+	}
+	return ""
+}
+
+// normalizeTags lowercases and trims tags, dropping empty and repeated ones.
+// The result is never nil so todos always serialize with a tags array.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// validatePriority returns why priority is unacceptable, or "" when it is
+// valid. An empty priority is valid and means the default.
+func validatePriority(priority string) string {
+	if priority != "" && priorityRanks[priority] == 0 {
+		return "Priority must be one of low, medium, high"
+	}
+	return ""
+}
+
+// Validate trims the title, then checks the todo for values that cannot be
+// stored. It returns nil or a ValidationErrors describing every offending field.
+func (t *Todo) Validate() error {
+	errs := ValidationErrors{}
+
+	t.Title = strings.TrimSpace(t.Title)
+	t.Tags = normalizeTags(t.Tags)
+
+	if msg := validateTitle(t.Title); msg != "" {
+		errs["title"] = msg
+	}
+
+	if msg := validatePriority(t.Priority); msg != "" {
+		errs["priority"] = msg
+	}
+
+	if msg := validateRecurrence(t.Recurrence); msg != "" {
+		errs["recurrence"] = msg
+	}
+
+	var msg string
+	if t.Subtasks, msg = normalizeSubtasks(t.Subtasks); msg != "" {
+		errs["subtasks"] = msg
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validationFailed responds 422 for a well-formed request whose values are
+// not acceptable. Malformed bodies and parameters stay 400.
+func (app *App) validationFailed(w http.ResponseWriter, err error) {
+	app.respondErrorDetails(w, http.StatusUnprocessableEntity, err.Error(), renderer.M{
+		"fields": err,
+	})
+}
+
+func (app *App) validateTodo(w http.ResponseWriter, r *http.Request) {
+	var todo Todo
+	if err := decodeJSON(w, r, &todo); err != nil {
+		app.invalidBody(w, err)
+		return
+	}
+
+	if err := todo.Validate(); err != nil {
+		app.respondJSON(w, http.StatusOK, renderer.M{
+			"valid":  false,
+			"errors": err,
+		})
+		return
+	}
+
+	app.respondJSON(w, http.StatusOK, renderer.M{
+		"valid": true,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTitle(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"valid", "Buy milk", ""},
+		{"empty", "", "Title is required"},
+		{"at the limit", strings.Repeat("a", maxTitleLength), ""},
+		{"over the limit", strings.Repeat("a", maxTitleLength+1), "Title must be at most 200 characters"},
+		// The limit counts characters, not bytes
+		{"multibyte at the limit", strings.Repeat("ü", maxTitleLength), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateTitle(tt.title); got != tt.want {
+				t.Errorf("validateTitle(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTodoValidateTitle(t *testing.T) {
+	tests := []struct {
+		name      string
+		title     string
+		wantTitle string
+		wantErr   string
+	}{
+		{"valid", "Buy milk", "Buy milk", ""},
+		{"trimmed", "  Buy milk\t", "Buy milk", ""},
+		{"whitespace only", "   ", "", "Title is required"},
+		{"over the limit", strings.Repeat("a", maxTitleLength+1), strings.Repeat("a", maxTitleLength+1), "Title must be at most 200 characters"},
+		// Surrounding whitespace does not count towards the limit
+		{"limit after trimming", " " + strings.Repeat("a", maxTitleLength) + " ", strings.Repeat("a", maxTitleLength), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo := Todo{Title: tt.title}
+			err := todo.Validate()
+
+			if todo.Title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", todo.Title, tt.wantTitle)
+			}
+			var got string
+			if errs, ok := err.(ValidationErrors); ok {
+				got = errs["title"]
+			} else if err != nil {
+				t.Fatalf("Validate() = %v, want ValidationErrors", err)
+			}
+			if got != tt.wantErr {
+				t.Errorf("title error = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}